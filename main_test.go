@@ -0,0 +1,3898 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// analyzerArgsEnv передаёт аргументы командной строки дочернему процессу теста,
+// который вместо тестов выполняет main (разделитель аргументов — \x1f)
+const analyzerArgsEnv = "ANALYZER_TEST_ARGS"
+
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv(analyzerArgsEnv); ok {
+		os.Args = []string{"dependency-analyzer"}
+		if args != "" {
+			os.Args = append(os.Args, strings.Split(args, "\x1f")...)
+		}
+		main()
+		os.Exit(ExitSuccess)
+	}
+	os.Exit(m.Run())
+}
+
+// runAnalyzer запускает программу с аргументами args в каталоге dir
+// (отдельным процессом, так как main завершается через os.Exit)
+func runAnalyzer(t *testing.T, dir string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), analyzerArgsEnv+"="+strings.Join(args, "\x1f"))
+
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &outBuf, &errBuf
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	default:
+		t.Fatalf("запуск анализатора: %v", err)
+	}
+	return outBuf.String(), errBuf.String(), code
+}
+
+// testRepo возвращает абсолютный путь к фикстуре из test_repos
+func testRepo(t *testing.T, name string) string {
+	t.Helper()
+	path, err := filepath.Abs(filepath.Join("test_repos", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeTestFile создаёт файл с содержимым во временном каталоге теста и возвращает путь
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeTestConfig записывает CSV-конфигурацию из пар ключ-значение (по строке на пару)
+func writeTestConfig(t *testing.T, dir string, lines ...string) string {
+	t.Helper()
+	return writeTestFile(t, dir, "config.csv", strings.Join(lines, "\n")+"\n")
+}
+
+// runWithConfig запускает программу во временном каталоге с конфигурацией из строк lines
+func runWithConfig(t *testing.T, lines ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestConfig(t, dir, lines...)
+	return runAnalyzer(t, dir, "config.csv")
+}
+
+// captureOutput выполняет fn, перенаправив os.Stdout и os.Stderr во временные файлы,
+// и возвращает записанное в каждый из них
+func captureOutput(t *testing.T, fn func()) (stdout, stderr string) {
+	t.Helper()
+	dir := t.TempDir()
+	outFile, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFile, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savedOut, savedErr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = outFile, errFile
+	defer func() {
+		os.Stdout, os.Stderr = savedOut, savedErr
+	}()
+	fn()
+
+	outFile.Close()
+	errFile.Close()
+	outData, _ := os.ReadFile(outFile.Name())
+	errData, _ := os.ReadFile(errFile.Name())
+	return string(outData), string(errData)
+}
+
+// loadTestConfig записывает индекс packages (если он не пуст) и конфигурацию
+// с корнем root и дополнительными строками extra, затем загружает её
+func loadTestConfig(t *testing.T, packages, root string, extra ...string) *Config {
+	t.Helper()
+	dir := t.TempDir()
+	repo := ""
+	if packages != "" {
+		repo = writeTestFile(t, dir, "Packages", packages)
+	}
+	lines := []string{"package_name," + root, "repository_url," + repo, "test_mode,true", "version,", "max_depth,10"}
+	filename := writeTestConfig(t, dir, append(lines, extra...)...)
+
+	config, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return config
+}
+
+// buildTestGraph строит граф по индексу packages, подавляя вывод хода построения
+func buildTestGraph(t *testing.T, packages, root string, extra ...string) *Graph {
+	t.Helper()
+	config := loadTestConfig(t, packages, root, extra...)
+	var graph *Graph
+	var err error
+	captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+	return graph
+}
+
+// TestUnknownConfigKeysWarnOnStderr: неизвестные ключи сообщаются в stderr с подсказкой,
+// а при strict_config=true становятся ошибкой конфигурации
+func TestUnknownConfigKeysWarnOnStderr(t *testing.T) {
+	dir := t.TempDir()
+	base := []string{
+		"package_name,A",
+		"repository_url,test_repos/simple_graph.txt",
+		"test_mode,true",
+		"version,",
+		"max_depth,3",
+		"max_dept,5",
+	}
+
+	filename := writeTestConfig(t, dir, base...)
+	var err error
+	stdout, stderr := captureOutput(t, func() { _, err = LoadConfig(filename) })
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if strings.Contains(stdout, "max_dept") {
+		t.Errorf("предупреждение попало в stdout: %q", stdout)
+	}
+	if !strings.Contains(stderr, "неизвестный параметр конфигурации: max_dept (возможно, max_depth)") {
+		t.Errorf("нет предупреждения с подсказкой в stderr: %q", stderr)
+	}
+
+	filename = writeTestConfig(t, dir, append(base, "strict_config,true")...)
+	if _, err := LoadConfig(filename); err == nil || !strings.Contains(err.Error(), "max_dept") {
+		t.Errorf("при strict_config ожидалась ошибка про max_dept, получено: %v", err)
+	}
+}
+
+// TestMachineFormatsKeepStdoutClean: при машиночитаемых форматах в stdout попадает
+// только результат, а ход работы («Загрузка данных», «Граф построен») — в stderr
+func TestMachineFormatsKeepStdoutClean(t *testing.T) {
+	for _, format := range []string{"jsonl", "stats-json", "tree-json", "summary", "tsort", "events"} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestConfig(t, dir,
+				"package_name,A",
+				"repository_url,"+testRepo(t, "cyclic_graph.txt"),
+				"test_mode,true",
+				"version,",
+				"max_depth,5",
+				"output_format,"+format,
+			)
+			stdout, stderr, code := runAnalyzer(t, dir, "config.csv")
+			if code != ExitSuccess {
+				t.Fatalf("код завершения %d, stderr: %s", code, stderr)
+			}
+			for _, noise := range []string{"Загрузка данных", "Найдено пакетов", "Граф построен", "Анализ завершен"} {
+				if strings.Contains(stdout, noise) {
+					t.Errorf("в stdout диагностика %q:\n%s", noise, stdout)
+				}
+				if !strings.Contains(stderr, noise) {
+					t.Errorf("в stderr нет диагностики %q", noise)
+				}
+			}
+
+			switch format {
+			case "jsonl", "events":
+				for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+					if !json.Valid([]byte(line)) {
+						t.Errorf("строка не является JSON: %q", line)
+					}
+				}
+			case "stats-json", "tree-json":
+				if !json.Valid([]byte(stdout)) {
+					t.Errorf("вывод не является JSON-документом:\n%s", stdout)
+				}
+			case "summary":
+				if lines := strings.Split(strings.TrimSpace(stdout), "\n"); len(lines) != 1 || !strings.HasPrefix(lines[0], "root=A ") {
+					t.Errorf("ожидалась одна строка summary, получено:\n%s", stdout)
+				}
+			case "tsort":
+				for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+					if len(strings.Fields(line)) != 2 {
+						t.Errorf("строка не является парой: %q", line)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestDiagnosticsFollowLogWriter: ход работы и предупреждения пишутся в поток
+// диагностики конфигурации, а не в os.Stdout
+func TestDiagnosticsFollowLogWriter(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B\nConflicts: C\n\n" +
+		"Package: B\nVersion: 1\nDepends: C\n\nPackage: C\nVersion: 1\n"
+	config := loadTestConfig(t, index, "A", "blacklist,C")
+	var log bytes.Buffer
+	config.logOut = &log
+
+	stdout, _ := captureOutput(t, func() {
+		graph, err := buildDependencyGraph(config)
+		if err != nil {
+			t.Errorf("buildDependencyGraph: %v", err)
+			return
+		}
+		printBlacklistReport(graph, config)
+	})
+	if stdout != "" {
+		t.Errorf("диагностика попала в os.Stdout:\n%s", stdout)
+	}
+	for _, want := range []string{"Загрузка данных", "Граф построен", "противоречивое замыкание", "=== Запрещённые пакеты ===", "- C: A -> B -> C"} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("в потоке диагностики нет %q:\n%s", want, log.String())
+		}
+	}
+}
+
+// TestKindMaxDepthLimitsRecommends: рекомендации не раскрываются глубже
+// max_depth_recommends, а узел, впервые встреченный с исчерпанным запасом,
+// раскрывается повторно, если до него есть путь с меньшим числом Recommends
+func TestKindMaxDepthLimitsRecommends(t *testing.T) {
+	const shallow = `Package: R
+Version: 1
+Recommends: S1
+
+Package: S1
+Version: 1
+Recommends: S2
+Depends: D1
+
+Package: S2
+Version: 1
+
+Package: D1
+Version: 1
+Depends: D2
+
+Package: D2
+Version: 1
+`
+	const rediscovered = `Package: R
+Version: 1
+Depends: M
+Recommends: Y
+
+Package: M
+Version: 1
+Depends: Y
+
+Package: Y
+Version: 1
+Recommends: W
+
+Package: W
+Version: 1
+`
+	for _, traversal := range []string{"dfs", "bfs"} {
+		t.Run(traversal, func(t *testing.T) {
+			extra := []string{"traversal," + traversal, `dependency_kinds,"depends,recommends"`, "max_depth_recommends,1"}
+
+			graph := buildTestGraph(t, shallow, "R", extra...)
+			for _, name := range []string{"S1", "D1", "D2"} {
+				if _, ok := graph.Nodes[name]; !ok {
+					t.Errorf("узел %s должен быть в графе", name)
+				}
+			}
+			if _, ok := graph.Nodes["S2"]; ok {
+				t.Errorf("S2 достижим только через две рекомендации и не должен раскрываться")
+			}
+
+			graph = buildTestGraph(t, rediscovered, "R", extra...)
+			if _, ok := graph.Nodes["W"]; !ok {
+				t.Errorf("W достижим по пути R -> M -> Y -> W с одной рекомендацией, но отсутствует в графе")
+			}
+		})
+	}
+}
+
+// TestCycleInducedNodes: пакет считается порождённым циклом, только если он выпадает
+// из графа при удалении замыкающих ребер циклов
+func TestCycleInducedNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		packages string
+		want     []string
+	}{
+		{
+			name: "цикл без зависимых пакетов",
+			packages: "Package: R\nVersion: 1.0\nDepends: A\n\n" +
+				"Package: A\nVersion: 1.0\nDepends: B, C\n\n" +
+				"Package: B\nVersion: 1.0\nDepends: A\n\n" +
+				"Package: C\nVersion: 1.0\n",
+			want: nil,
+		},
+		{
+			name: "цикл питает иначе недостижимый пакет",
+			packages: "Package: R\nVersion: 1.0\nDepends: B\n\n" +
+				"Package: B\nVersion: 1.0\nDepends: A\n\n" +
+				"Package: A\nVersion: 1.0\nDepends: B, X\n\n" +
+				"Package: X\nVersion: 1.0\n",
+			want: []string{"X"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph := buildTestGraph(t, tt.packages, "R")
+			if len(graph.Cycles) == 0 {
+				t.Fatal("цикл не обнаружен")
+			}
+			if got := graph.CycleInducedNodes(); !slices.Equal(got, tt.want) {
+				t.Errorf("CycleInducedNodes() = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCyclesIdenticalAcrossTraversals: DFS и BFS одного графа дают одинаковые
+// циклы в каноническом виде и одинаковом порядке
+func TestCyclesIdenticalAcrossTraversals(t *testing.T) {
+	data, err := os.ReadFile(testRepo(t, "cyclic_graph.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range []string{"A", "E"} {
+		dfs := buildTestGraph(t, string(data), root, "traversal,dfs")
+		bfs := buildTestGraph(t, string(data), root, "traversal,bfs")
+		if len(dfs.Cycles) == 0 {
+			t.Fatalf("%s: циклы не обнаружены", root)
+		}
+		if !slices.Equal(dfs.Cycles, bfs.Cycles) {
+			t.Errorf("%s: DFS %q, BFS %q", root, dfs.Cycles, bfs.Cycles)
+		}
+		for _, cycle := range dfs.Cycles {
+			if normalizeCycle(cycle) != cycle {
+				t.Errorf("%s: цикл %q не в каноническом виде", root, cycle)
+			}
+		}
+		if !slices.IsSorted(dfs.Cycles) {
+			t.Errorf("%s: циклы не отсортированы: %q", root, dfs.Cycles)
+		}
+	}
+}
+
+// TestBreakVersionTie: среди кандидатов одной версии решает порядок источников,
+// затем родная архитектура, и лишь затем имя архитектуры
+func TestBreakVersionTie(t *testing.T) {
+	amd64 := Package{Name: "lib", Version: "1.0", Architecture: "amd64", Source: "main", SourceIndex: 0}
+	arm64 := Package{Name: "lib", Version: "1.0", Architecture: "arm64", Source: "main", SourceIndex: 0}
+	later := Package{Name: "lib", Version: "1.0", Architecture: "arm64", Source: "contrib", SourceIndex: 1}
+
+	tests := []struct {
+		name   string
+		list   []Package
+		native string
+		want   Package
+	}{
+		{"родная архитектура важнее имени", []Package{amd64, arm64}, "arm64", arm64},
+		{"без родной — по имени архитектуры", []Package{arm64, amd64}, "", amd64},
+		{"порядок источников важнее архитектуры", []Package{later, amd64}, "arm64", amd64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 3 {
+				got, tied := breakVersionTie(tt.list, tt.list[0], tt.native)
+				if got.Source != tt.want.Source || got.Architecture != tt.want.Architecture {
+					t.Fatalf("выбран %s/%s, ожидался %s/%s", got.Source, got.Architecture, tt.want.Source, tt.want.Architecture)
+				}
+				if tied != len(tt.list) {
+					t.Errorf("кандидатов %d, ожидалось %d", tied, len(tt.list))
+				}
+			}
+		})
+	}
+}
+
+// TestCandidateVersionOrder: из нескольких версий выбирается самая новая,
+// подходящая под ограничение, независимо от порядка записей в индексе;
+// о неоднозначном выборе среди одинаковых версий сообщается в stderr один раз
+func TestCandidateVersionOrder(t *testing.T) {
+	const index = "Package: app\nVersion: 1\nArchitecture: amd64\nDepends: lib, old, tool\n\n" +
+		"Package: old\nVersion: 1\nArchitecture: amd64\nDepends: lib (<< 2.0)\n\n" +
+		"Package: tool\nVersion: 1\nArchitecture: amd64\nDepends: lib\n\n" +
+		"Package: lib\nVersion: 1.0\nArchitecture: amd64\n\n" +
+		"Package: lib\nVersion: 2.0\nArchitecture: all\n\n" +
+		"Package: lib\nVersion: 2.0\nArchitecture: amd64\n\n" +
+		"Package: lib\nVersion: 1.5\nArchitecture: amd64\n"
+	config := loadTestConfig(t, index, "app")
+
+	var packages []Package
+	var err error
+	captureOutput(t, func() { packages, err = loadPackages(config) })
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	var graph *Graph
+	_, stderr := captureOutput(t, func() {
+		graph, err = buildGraphFromPackages(config, packages)
+		if err == nil {
+			resolveNode(graph, config, nil, "lib", "", 2)
+		}
+	})
+	if err != nil {
+		t.Fatalf("buildGraphFromPackages: %v", err)
+	}
+	if lib := graph.Nodes["lib"]; lib == nil || lib.Version != "2.0" {
+		t.Errorf("lib: %+v, ожидалась самая новая версия 2.0", lib)
+	}
+	if got := strings.Count(stderr, "Внимание: кандидатов lib [2.0]: 2, выбран из"); got != 1 {
+		t.Errorf("предупреждение о выборе lib выведено %d раз, ожидался 1:\n%s", got, stderr)
+	}
+
+	// Ограничение родителя отсекает 2.0: из оставшихся берётся 1.5, а не первая запись
+	node, found := resolveNode(&Graph{PackageSource: graph.PackageSource}, config, nil, "lib", "<< 2.0", 1)
+	if !found || node.Version != "1.5" {
+		t.Errorf("при << 2.0 выбрана %s, ожидалась 1.5", node.Version)
+	}
+}
+
+// writeTestTarball создаёт tar.gz-архив с членами files (имя -> содержимое)
+func writeTestTarball(t *testing.T, dir, name string, files map[string]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for member, content := range files {
+		header := &tar.Header{Name: member, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writeTestFile(t, dir, name, buf.String())
+}
+
+// TestTarballRepository: индекс читается из члена Packages архива .tar.gz,
+// reader закрывает файл архива, а архив без Packages — ошибка разбора
+func TestTarballRepository(t *testing.T) {
+	dir := t.TempDir()
+	index, err := os.ReadFile(testRepo(t, "simple_graph.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := writeTestTarball(t, dir, "repo.tar.gz", map[string]string{"dists/main/Packages": string(index)})
+
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := openTarPackages(archive, file)
+	if err != nil {
+		t.Fatalf("openTarPackages: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(index) {
+		t.Errorf("прочитано не содержимое Packages: %q", data)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := file.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("файл архива не закрыт: %v", err)
+	}
+
+	empty := writeTestTarball(t, dir, "empty.tar.gz", map[string]string{"README": "нет индекса"})
+	config := &Config{TestMode: true}
+	if _, err := fetchPackagesFile(empty, config); err == nil || exitCodeFor(err) != ExitParseError {
+		t.Errorf("для архива без Packages ожидалась ошибка разбора, получено: %v", err)
+	}
+
+	writeTestConfig(t, dir, "package_name,A", "repository_url,"+archive, "test_mode,true", "version,", "max_depth,5")
+	stdout, stderr, code := runAnalyzer(t, dir, "config.csv")
+	if code != ExitSuccess {
+		t.Fatalf("код завершения %d, stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Граф построен") {
+		t.Errorf("граф по архиву не построен:\n%s", stdout)
+	}
+}
+
+// TestStrictNamesAllRelationFields: при strict_names некорректные имена отбрасываются
+// во всех полях отношений с предупреждением в stderr, а при fail_on_invalid_names
+// разбор завершается ошибкой
+func TestStrictNamesAllRelationFields(t *testing.T) {
+	index := "Package: app\nVersion: 1.0\n" +
+		"Depends: libgood, Bad_Dep\n" +
+		"Pre-Depends: libpre, Bad_Pre\n" +
+		"Recommends: librec, Bad_Rec\n" +
+		"Suggests: libsug, Bad_Sug\n" +
+		"Provides: virt, Bad_Prov\n" +
+		"Replaces: old, Bad_Repl\n"
+
+	var packages []Package
+	var err error
+	stdout, stderr := captureOutput(t, func() {
+		packages, _, err = parsePackagesFile(strings.NewReader(index), ParseOptions{StrictNames: true})
+	})
+	if err != nil {
+		t.Fatalf("parsePackagesFile: %v", err)
+	}
+	if stdout != "" {
+		t.Errorf("предупреждения попали в stdout: %q", stdout)
+	}
+	pkg := packages[0]
+	fields := map[string][]string{
+		"Depends":     pkg.Dependencies,
+		"Pre-Depends": pkg.PreDepends,
+		"Recommends":  pkg.Recommends,
+		"Suggests":    pkg.Suggests,
+		"Provides":    pkg.Provides,
+		"Replaces":    pkg.Replaces,
+	}
+	for field, names := range fields {
+		if len(names) != 1 || strings.HasPrefix(names[0], "Bad_") {
+			t.Errorf("%s: ожидалось одно корректное имя, получено %q", field, names)
+		}
+		if !strings.Contains(stderr, "в поле "+field+" пропущено") {
+			t.Errorf("нет предупреждения для поля %s: %q", field, stderr)
+		}
+	}
+
+	captureOutput(t, func() {
+		_, _, err = parsePackagesFile(strings.NewReader(index), ParseOptions{StrictNames: true, FailOnInvalid: true})
+	})
+	if err == nil || exitCodeFor(err) != ExitParseError {
+		t.Errorf("при fail_on_invalid_names ожидалась ошибка разбора, получено: %v", err)
+	}
+
+	_, stderr = captureOutput(t, func() {
+		_, _, err = parsePackagesFile(strings.NewReader(index), ParseOptions{FailOnInvalid: true})
+	})
+	if err != nil || stderr != "" {
+		t.Errorf("без strict_names имена не проверяются: %v, %q", err, stderr)
+	}
+}
+
+// failingWriter принимает limit байт, после чего возвращает ошибку
+type failingWriter struct {
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errors.New("диск заполнен")
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+// TestStreamTreeJSONMatchesInMemory: потоковый tree-json — корректный JSON,
+// совпадающий с json.Marshal для дерева в памяти, а ошибки записи возвращаются
+func TestStreamTreeJSONMatchesInMemory(t *testing.T) {
+	for _, fixture := range []string{"simple_graph.txt", "deep_graph.txt", "cyclic_graph.txt"} {
+		t.Run(fixture, func(t *testing.T) {
+			data, err := os.ReadFile(testRepo(t, fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			graph := buildTestGraph(t, string(data), "A")
+			config := loadTestConfig(t, string(data), "A")
+
+			var streamed bytes.Buffer
+			if err := writeTreeJSON(&streamed, graph, config); err != nil {
+				t.Fatalf("writeTreeJSON: %v", err)
+			}
+			if !json.Valid(streamed.Bytes()) {
+				t.Fatalf("некорректный JSON:\n%s", streamed.String())
+			}
+
+			inMemory, err := json.Marshal(buildTreeJSON(graph, config, "A", 0, make(map[string]bool)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := string(inMemory) + "\n"; streamed.String() != want {
+				t.Errorf("потоковый вывод отличается:\n%s\nожидалось:\n%s", streamed.String(), want)
+			}
+
+			for _, limit := range []int{0, 10, streamed.Len() / 2} {
+				if err := streamTreeJSON(&failingWriter{limit: limit}, graph, config, "A", 0, make(map[string]bool)); err == nil {
+					t.Errorf("ошибка записи после %d байт не возвращена", limit)
+				}
+				if err := writeTreeJSON(&failingWriter{limit: limit}, graph, config); err == nil {
+					t.Errorf("writeTreeJSON: ошибка записи после %d байт не возвращена", limit)
+				}
+			}
+		})
+	}
+}
+
+// TestVersionsWithoutGraph: output_format=versions выводит версии от новой к старой
+// сразу после разбора индекса, не строя граф
+func TestVersionsWithoutGraph(t *testing.T) {
+	dir := t.TempDir()
+	index := writeTestFile(t, dir, "Packages",
+		"Package: app\nVersion: 1.0\nDepends: missing-dep\n\n"+
+			"Package: app\nVersion: 1:0.5\n\n"+
+			"Package: app\nVersion: 2.0~rc1\n")
+	writeTestConfig(t, dir, "package_name,app", "repository_url,"+index, "test_mode,true",
+		"version,1.0", "max_depth,5", "output_format,versions")
+
+	stdout, stderr, code := runAnalyzer(t, dir, "config.csv")
+	if code != ExitSuccess {
+		t.Fatalf("код завершения %d, stderr: %s", code, stderr)
+	}
+	if strings.Contains(stdout, "Построение графа") || strings.Contains(stdout, "Граф построен") {
+		t.Errorf("граф строился:\n%s", stdout)
+	}
+	want := "  1. 1:0.5\n  2. 2.0~rc1\n  3. 1.0 ← выбрана\n"
+	if !strings.Contains(stdout, want) {
+		t.Errorf("ожидался список\n%s\nполучено:\n%s", want, stdout)
+	}
+
+	writeTestConfig(t, dir, "package_name,ap", "repository_url,"+index, "test_mode,true",
+		"version,", "max_depth,5", "output_format,versions")
+	if _, stderr, code := runAnalyzer(t, dir, "config.csv"); code != ExitNotFound {
+		t.Errorf("для отсутствующего пакета ожидался код %d, получен %d: %s", ExitNotFound, code, stderr)
+	}
+}
+
+// TestRepositoryURLListRelativeToConfig: repository_url=@файл читается относительно
+// каталога конфигурации, а оба источника из списка объединяются
+func TestRepositoryURLListRelativeToConfig(t *testing.T) {
+	dir := t.TempDir()
+	first := writeTestFile(t, dir, "first.txt", "Package: A\nVersion: 1.0\nDepends: B\n")
+	second := writeTestFile(t, dir, "second.txt", "Package: B\nVersion: 1.0\n")
+	writeTestFile(t, dir, "conf/urls.txt", "# источники\n"+first+"\n\n"+second+"\n")
+	filename := writeTestFile(t, dir, "conf/config.csv",
+		"package_name,A\nrepository_url,@urls.txt\ntest_mode,true\nversion,\nmax_depth,5\n")
+
+	// Рабочий каталог — не каталог конфигурации
+	config, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if !slices.Equal(config.RepositorySources, []string{first, second}) {
+		t.Fatalf("источники: %q", config.RepositorySources)
+	}
+
+	var graph *Graph
+	captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+	if node := graph.Nodes["B"]; node == nil || node.Version != "1.0" {
+		t.Errorf("пакет из второго источника не найден: %+v", node)
+	}
+}
+
+// TestExplainConfigSources: explain-config показывает источник каждого значения,
+// причём флаг важнее переменной окружения, а она — файла
+func TestExplainConfigSources(t *testing.T) {
+	dir := t.TempDir()
+	writeTestConfig(t, dir,
+		"package_name,A",
+		"repository_url,"+testRepo(t, "simple_graph.txt"),
+		"test_mode,true",
+		"version,",
+		"max_depth,3",
+		"indent_width,4",
+	)
+	t.Setenv(configEnvPrefix+"INDENT_WIDTH", "6")
+	t.Setenv(configEnvPrefix+"OUTPUT_FORMAT", "summary")
+
+	stdout, stderr, code := runAnalyzer(t, dir, "explain-config", "--max_depth=7", "--output_format=jsonl", "config.csv")
+	if code != ExitSuccess {
+		t.Fatalf("код завершения %d, stderr: %s", code, stderr)
+	}
+	sources := make(map[string][2]string)
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 {
+			sources[fields[0]] = [2]string{fields[1], fields[2]}
+		}
+	}
+	want := map[string][2]string{
+		"max_depth":     {"7", "[flag]"},
+		"output_format": {"jsonl", "[flag]"},
+		"indent_width":  {"6", "[env]"},
+		"package_name":  {"A", "[file]"},
+		"traversal":     {"dfs", "[default]"},
+	}
+	for key, expected := range want {
+		if sources[key] != expected {
+			t.Errorf("%s: получено %q, ожидалось %q", key, sources[key], expected)
+		}
+	}
+
+	if _, _, code := runAnalyzer(t, dir, "--max_dept=7", "config.csv"); code != ExitConfigError {
+		t.Errorf("для неизвестного флага ожидался код %d, получен %d", ExitConfigError, code)
+	}
+}
+
+// TestMergeRootsVersionQualifiedNodes: при merge_roots общий пакет одной версии —
+// один узел, а разные версии, нужные разным корням, — узлы «имя=версия»
+func TestMergeRootsVersionQualifiedNodes(t *testing.T) {
+	index := "Package: app1\nVersion: 1.0\nDepends: lib (= 1.0), common\nConflicts: lib (>= 2.0)\n\n" +
+		"Package: app2\nVersion: 1.0\nDepends: lib (= 2.0), common\n\n" +
+		"Package: lib\nVersion: 2.0\nDepends: common\n\n" +
+		"Package: lib\nVersion: 1.0\n\n" +
+		"Package: common\nVersion: 1.0\n"
+	config := loadTestConfig(t, index, "app*", "root_glob,true", "merge_roots,true")
+
+	var graphs []*Graph
+	var err error
+	stdout, _ := captureOutput(t, func() { graphs, err = buildRootGraphs(config) })
+	if err != nil {
+		t.Fatalf("buildRootGraphs: %v", err)
+	}
+	if len(graphs) != 1 {
+		t.Fatalf("ожидался один объединённый граф, получено %d", len(graphs))
+	}
+	graph := graphs[0]
+
+	for name, version := range map[string]string{"lib=1.0": "1.0", "lib=2.0": "2.0", "common": "1.0"} {
+		if node := graph.Nodes[name]; node == nil || node.Version != version {
+			t.Errorf("узел %s: %+v, ожидалась версия %s", name, node, version)
+		}
+	}
+	if _, exists := graph.Nodes["lib"]; exists {
+		t.Error("разные версии lib схлопнуты в один узел")
+	}
+	wantEdges := map[string][]string{
+		"app*":    {"app1", "app2"},
+		"app1":    {"lib=1.0", "common"},
+		"app2":    {"lib=2.0", "common"},
+		"lib=2.0": {"common"},
+	}
+	for name, want := range wantEdges {
+		if got := graph.Edges[name]; !slices.Equal(got, want) {
+			t.Errorf("рёбра %s: %v, ожидалось %v", name, got, want)
+		}
+	}
+	if !strings.Contains(stdout, "корням нужны разные версии lib (1.0, 2.0)") {
+		t.Errorf("нет предупреждения о расхождении версий:\n%s", stdout)
+	}
+
+	// Conflicts app1 касается и версии lib, которую тянет app2
+	wantConflicts := []Conflict{{Node: "app1", Relation: "Conflicts", Target: "lib=2.0", Constraint: ">= 2.0"}}
+	if got := graph.Conflicts(); !slices.Equal(got, wantConflicts) {
+		t.Errorf("Conflicts() = %+v, ожидалось %+v", got, wantConflicts)
+	}
+
+	// Узел одной версии, раскрытый у второго корня полнее, получает его рёбра
+	short := &Graph{Root: "app1", Nodes: map[string]*Node{
+		"app1": {Name: "app1", Version: "1", Dependencies: []string{"lib"}},
+		"lib":  {Name: "lib", Version: "1", Depth: 1, Dependencies: []string{}},
+	}, Edges: map[string][]string{"app1": {"lib"}}}
+	full := &Graph{Root: "app2", Nodes: map[string]*Node{
+		"app2": {Name: "app2", Version: "1", Dependencies: []string{"lib"}},
+		"lib":  {Name: "lib", Version: "1", Depth: 1, Dependencies: []string{"base"}, Constraints: map[string]string{"base": ">= 1"}},
+		"base": {Name: "base", Version: "1", Depth: 2, Dependencies: []string{}},
+	}, Edges: map[string][]string{"app2": {"lib"}, "lib": {"base"}, "base": {}}}
+	var merged *Graph
+	captureOutput(t, func() { merged = mergeRootGraphs([]*Graph{short, full}, config) })
+	if got := merged.Edges["lib"]; !slices.Equal(got, []string{"base"}) {
+		t.Errorf("рёбра lib после объединения: %v, ожидалось [base]", got)
+	}
+	if lib := merged.Nodes["lib"]; lib.Constraints["base"] != ">= 1" {
+		t.Errorf("ограничение lib -> base потеряно: %+v", lib.Constraints)
+	}
+
+	dir := t.TempDir()
+	filename := writeTestConfig(t, dir, "package_name,app1", "repository_url,x", "test_mode,true",
+		"version,", "max_depth,3", "merge_roots,true")
+	if _, err := LoadConfig(filename); err == nil {
+		t.Error("merge_roots без root_glob должен быть ошибкой конфигурации")
+	}
+}
+
+// TestExitCodes: типичные ошибки завершаются кодами из контракта
+func TestExitCodes(t *testing.T) {
+	cyclic := testRepo(t, "cyclic_graph.txt")
+	tests := []struct {
+		name  string
+		lines []string
+		want  int
+	}{
+		{"успех", []string{"package_name,A", "repository_url," + cyclic, "test_mode,true", "version,", "max_depth,3"}, ExitSuccess},
+		{"ошибка конфигурации", []string{"package_name,A", "repository_url," + cyclic, "test_mode,true", "version,", "max_depth,0"}, ExitConfigError},
+		{"нет файла индекса", []string{"package_name,A", "repository_url,нет.txt", "test_mode,true", "version,", "max_depth,3"}, ExitFetchError},
+		{"пакет не найден", []string{"package_name,Z", "repository_url," + cyclic, "test_mode,true", "version,", "max_depth,3"}, ExitNotFound},
+		{"цикл при fail_on_cycle", []string{"package_name,A", "repository_url," + cyclic, "test_mode,true", "version,", "max_depth,5", "fail_on_cycle,true"}, ExitCycleError},
+		{"запрещённый пакет", []string{"package_name,A", "repository_url," + cyclic, "test_mode,true", "version,", "max_depth,5", "blacklist,D", "fail_on_blacklist,true"}, ExitBlacklisted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, stderr, code := runWithConfig(t, tt.lines...); code != tt.want {
+				t.Errorf("код завершения %d, ожидался %d; stderr: %s", code, tt.want, stderr)
+			}
+		})
+	}
+}
+
+// TestIncludeDescription: краткое описание из индекса попадает в узел и,
+// при include_description, в вывод
+func TestIncludeDescription(t *testing.T) {
+	index := "Package: app\nVersion: 1.0\nDepends: lib\nDescription: приложение\n  подробности\n\n" +
+		"Package: lib\nVersion: 2.0\nDescription: библиотека\n"
+	config := loadTestConfig(t, index, "app", "include_description,true")
+	var graph *Graph
+	var err error
+	captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+	if got := graph.Nodes["lib"].Description; got != "библиотека" {
+		t.Errorf("описание узла lib: %q", got)
+	}
+
+	record := newNodeRecord(graph.Nodes["app"], config)
+	if record.Description != "приложение" {
+		t.Errorf("описание в записи узла: %q", record.Description)
+	}
+	config.IncludeDescription = false
+	if record := newNodeRecord(graph.Nodes["app"], config); record.Description != "" {
+		t.Errorf("без include_description описание выводится: %q", record.Description)
+	}
+}
+
+// TestWhyPathIsValidChain: цепочка why — путь по рёбрам графа между корнем и пакетом
+func TestWhyPathIsValidChain(t *testing.T) {
+	data, err := os.ReadFile(testRepo(t, "cyclic_graph.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph := buildTestGraph(t, string(data), "A")
+
+	path, err := findWhyPath(graph, "A", "D")
+	if err != nil {
+		t.Fatalf("findWhyPath: %v", err)
+	}
+	if len(path) != 3 || path[0] != "A" || path[len(path)-1] != "D" {
+		t.Fatalf("цепочка %v: ожидался кратчайший путь от A до D", path)
+	}
+	for i := 1; i < len(path); i++ {
+		if !slices.Contains(graph.Edges[path[i-1]], path[i]) {
+			t.Errorf("в графе нет ребра %s -> %s", path[i-1], path[i])
+		}
+	}
+
+	if _, err := findWhyPath(graph, "A", "E"); err == nil {
+		t.Error("для пакета вне графа ожидалась ошибка")
+	}
+
+	dir := t.TempDir()
+	writeTestConfig(t, dir, "package_name,A", "repository_url,"+testRepo(t, "cyclic_graph.txt"),
+		"test_mode,true", "version,", "max_depth,5")
+	stdout, stderr, code := runAnalyzer(t, dir, "why", "D", "config.csv")
+	if code != ExitSuccess {
+		t.Fatalf("код завершения %d, stderr: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Кратчайшая цепочка: "+strings.Join(path, " -> ")) {
+		t.Errorf("в выводе why нет цепочки %v:\n%s", path, stdout)
+	}
+}
+
+// TestIndentWidth: отступ уровня текстового дерева равен indent_width пробелам
+func TestIndentWidth(t *testing.T) {
+	data, err := os.ReadFile(testRepo(t, "deep_graph.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, width := range []int{2, 4} {
+		config := loadTestConfig(t, string(data), "A", "indent_width,"+strconv.Itoa(width))
+		graph := buildTestGraph(t, string(data), "A")
+
+		var out bytes.Buffer
+		printGraph(&out, graph, config)
+		for depth, name := range []string{"A", "B", "C"} {
+			want := "\n" + strings.Repeat(" ", depth*width) + "- " + name + " [1.0]"
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("indent_width=%d: нет строки %q:\n%s", width, want, out.String())
+			}
+		}
+	}
+}
+
+// TestHTTPProxy: при http_proxy запрос к зеркалу идёт через заданный прокси
+func TestHTTPProxy(t *testing.T) {
+	index := "Package: A\nVersion: 1.0\n"
+	var requested string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		io.WriteString(w, index)
+	}))
+	defer proxy.Close()
+
+	config := &Config{HTTPProxy: proxy.URL}
+	reader, err := fetchPackagesFile("http://mirror.invalid/ubuntu/Packages", config)
+	if err != nil {
+		t.Fatalf("fetchPackagesFile: %v", err)
+	}
+	defer reader.(io.Closer).Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != index {
+		t.Errorf("получено %q", data)
+	}
+	if requested != "http://mirror.invalid/ubuntu/Packages" {
+		t.Errorf("прокси получил запрос %q", requested)
+	}
+
+	if _, err := newHTTPClient(&Config{HTTPProxy: "://неверный"}); err == nil {
+		t.Error("для неверного адреса прокси ожидалась ошибка")
+	}
+}
+
+// TestTLSCAFile: самоподписанный сертификат зеркала принимается только с tls_ca_file
+// (или при tls_insecure)
+func TestTLSCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Package: A\nVersion: 1.0\n")
+	}))
+	defer server.Close()
+
+	caFile := writeTestFile(t, t.TempDir(), "ca.pem",
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+
+	fetch := func(config *Config) error {
+		reader, err := fetchPackagesFile(server.URL+"/Packages", config)
+		if err == nil {
+			reader.(io.Closer).Close()
+		}
+		return err
+	}
+	if err := fetch(&Config{}); err == nil {
+		t.Error("самоподписанный сертификат принят без tls_ca_file")
+	}
+	if err := fetch(&Config{TLSCAFile: caFile}); err != nil {
+		t.Errorf("с tls_ca_file: %v", err)
+	}
+	captureOutput(t, func() {
+		if err := fetch(&Config{TLSInsecure: true}); err != nil {
+			t.Errorf("с tls_insecure: %v", err)
+		}
+	})
+}
+
+// TestDepthHistogram: число узлов на каждой глубине известного графа
+func TestDepthHistogram(t *testing.T) {
+	data, err := os.ReadFile(testRepo(t, "cyclic_graph.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph := buildTestGraph(t, string(data), "A")
+
+	want := map[int]int{0: 1, 1: 2, 2: 1}
+	if got := graph.DepthHistogram(); !maps.Equal(got, want) {
+		t.Errorf("DepthHistogram() = %v, ожидалось %v", got, want)
+	}
+
+	var out bytes.Buffer
+	printDepthHistogram(&out, graph)
+	if !strings.Contains(out.String(), "  1 | 2     ") {
+		t.Errorf("нет строки для глубины 1:\n%s", out.String())
+	}
+}
+
+// TestStreamNodesOrder: при stream_nodes узлы выводятся в порядке обхода
+func TestStreamNodesOrder(t *testing.T) {
+	data, err := os.ReadFile(testRepo(t, "cyclic_graph.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := loadTestConfig(t, string(data), "A", "stream_nodes,true")
+
+	var visited []string
+	config.ProgressFunc = func(event ProgressEvent) {
+		if node, ok := event.(NodeVisited); ok {
+			visited = append(visited, node.Name)
+		}
+		streamProgress(config)(event)
+	}
+	stdout, _ := captureOutput(t, func() {
+		if _, err := buildDependencyGraph(config); err != nil {
+			t.Errorf("buildDependencyGraph: %v", err)
+		}
+	})
+
+	var streamed []string
+	for _, line := range strings.Split(stdout, "\n") {
+		if _, rest, ok := strings.Cut(line, "  + [depth "); ok {
+			fields := strings.Fields(rest)
+			streamed = append(streamed, fields[1])
+		}
+	}
+	// DFS снимает со стека последнюю зависимость первой: A, C, D, затем B
+	want := []string{"A", "C", "D", "B"}
+	if !slices.Equal(visited, want) {
+		t.Fatalf("порядок обхода %v, ожидался %v", visited, want)
+	}
+	if !slices.Equal(streamed, want) {
+		t.Errorf("выведено %v, порядок обхода %v", streamed, want)
+	}
+}
+
+// TestMirrorFallbacks: при ошибке 500 основного зеркала индекс берётся с резервного
+// по тому же пути dists/
+func TestMirrorFallbacks(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "сбой", http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+
+	var fallbackPath string
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackPath = r.URL.Path
+		io.WriteString(w, "Package: A\nVersion: 1.0\n")
+	}))
+	defer fallback.Close()
+
+	config := &Config{
+		RepositoryURL:   primary.URL + "/ubuntu/dists/focal/main/binary-amd64/Packages",
+		MirrorFallbacks: []string{fallback.URL + "/mirror"},
+	}
+	var reader io.Reader
+	var err error
+	stdout, _ := captureOutput(t, func() { reader, err = fetchWithFallbacks(config) })
+	if err != nil {
+		t.Fatalf("fetchWithFallbacks: %v", err)
+	}
+	defer reader.(io.Closer).Close()
+	data, _ := io.ReadAll(reader)
+	if !strings.Contains(string(data), "Package: A") {
+		t.Errorf("получено %q", data)
+	}
+	if fallbackPath != "/mirror/dists/focal/main/binary-amd64/Packages" {
+		t.Errorf("резервное зеркало запрошено по пути %q", fallbackPath)
+	}
+	if !strings.Contains(stdout, "статус 500") {
+		t.Errorf("нет сообщения об ошибке основного зеркала:\n%s", stdout)
+	}
+
+	config.MirrorFallbacks = []string{primary.URL}
+	captureOutput(t, func() { _, err = fetchWithFallbacks(config) })
+	if exitCodeFor(err) != ExitFetchError {
+		t.Errorf("при недоступности всех зеркал ожидался код %d: %v", ExitFetchError, err)
+	}
+}
+
+// TestFetchRetries: временный сбой (5xx) повторяется с паузой до перехода к
+// зеркалу, 404 не повторяется, а test_mode не отключает зеркала для сетевого адреса
+func TestFetchRetries(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		count := requests[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/flaky/") && count <= 2:
+			http.Error(w, "сбой", http.StatusServiceUnavailable)
+		case strings.HasPrefix(r.URL.Path, "/missing/"):
+			http.NotFound(w, r)
+		default:
+			io.WriteString(w, "Package: A\nVersion: 1.0\n")
+		}
+	}))
+	defer server.Close()
+
+	const path = "/dists/focal/main/binary-amd64/Packages"
+	config := &Config{
+		RepositoryURL:   server.URL + "/flaky" + path,
+		MirrorFallbacks: []string{server.URL + "/mirror"},
+		FetchRetries:    2,
+		RetryBackoff:    time.Millisecond,
+	}
+	var reader io.Reader
+	var err error
+	stdout, _ := captureOutput(t, func() { reader, err = fetchWithFallbacks(config) })
+	if err != nil {
+		t.Fatalf("fetchWithFallbacks: %v", err)
+	}
+	reader.(io.Closer).Close()
+	if requests["/flaky"+path] != 3 || requests["/mirror"+path] != 0 {
+		t.Errorf("запросы %v: ожидались 3 попытки основного адреса без перехода на зеркало", requests)
+	}
+	if !strings.Contains(stdout, "повтор 2/2 через 2ms") {
+		t.Errorf("нет сообщения о повторе с удвоенной паузой:\n%s", stdout)
+	}
+
+	// 404 не повторяется; в test_mode сетевой адрес всё равно переносится на зеркало
+	config.RepositoryURL = server.URL + "/missing" + path
+	config.TestMode = true
+	captureOutput(t, func() { reader, err = fetchWithFallbacks(config) })
+	if err != nil {
+		t.Fatalf("fetchWithFallbacks: %v", err)
+	}
+	reader.(io.Closer).Close()
+	if requests["/missing"+path] != 1 || requests["/mirror"+path] != 1 {
+		t.Errorf("запросы %v: ожидались одна попытка основного адреса и загрузка с зеркала", requests)
+	}
+
+	// Локальный файл на зеркала не переносится
+	config.RepositoryURL = filepath.Join(t.TempDir(), "dists", "focal", "Packages")
+	captureOutput(t, func() { _, err = fetchWithFallbacks(config) })
+	if err == nil || !strings.Contains(err.Error(), "ошибка открытия локального файла") {
+		t.Errorf("ожидалась ошибка открытия локального файла, получено %v", err)
+	}
+}
+
+// TestDuplicatePackagesWarning: повторная запись имя+версия сообщается при построении
+func TestDuplicatePackagesWarning(t *testing.T) {
+	index := "Package: A\nVersion: 1.0\nDepends: B\n\n" +
+		"Package: B\nVersion: 1.0\n\n" +
+		"Package: B\nVersion: 1.0\n\n" +
+		"Package: B\nVersion: 2.0\n"
+	packages, _, err := parsePackagesFile(strings.NewReader(index), ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := findDuplicatePackages(packages); !slices.Equal(got, []string{"B=1.0 (x2)"}) {
+		t.Errorf("findDuplicatePackages() = %v", got)
+	}
+
+	config := loadTestConfig(t, index, "A")
+	stdout, _ := captureOutput(t, func() {
+		if _, err := buildDependencyGraph(config); err != nil {
+			t.Errorf("buildDependencyGraph: %v", err)
+		}
+	})
+	if !strings.Contains(stdout, "повторяющиеся записи пакетов") || !strings.Contains(stdout, "  - B=1.0 (x2)") {
+		t.Errorf("нет предупреждения о дубликате:\n%s", stdout)
+	}
+}
+
+// TestWriteFileAtomic: при ошибке записи целевой файл не меняется и временных
+// файлов не остаётся, а при успехе содержимое заменяется целиком
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	target := writeTestFile(t, dir, "out.txt", "старое содержимое")
+
+	err := writeFileAtomic(target, func(w io.Writer) error {
+		io.WriteString(w, "частичный вывод")
+		return errors.New("сбой записи")
+	})
+	if err == nil {
+		t.Fatal("ошибка записи не возвращена")
+	}
+	if data, _ := os.ReadFile(target); string(data) != "старое содержимое" {
+		t.Errorf("целевой файл изменён: %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("остались временные файлы: %v", entries)
+	}
+
+	if err := writeFileAtomic(target, func(w io.Writer) error {
+		_, err := io.WriteString(w, "новое содержимое")
+		return err
+	}); err != nil {
+		t.Fatalf("writeFileAtomic: %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "новое содержимое" {
+		t.Errorf("содержимое после записи: %q", data)
+	}
+}
+
+// wideIndex формирует индекс широкого графа: корень root зависит от width пакетов,
+// каждый из которых начинает цепочку длины depth и зависит от общего пакета shared
+func wideIndex(width, depth int) string {
+	var b strings.Builder
+	rootDeps := make([]string, width)
+	for i := range width {
+		rootDeps[i] = fmt.Sprintf("p%d-0", i)
+		for level := range depth {
+			fmt.Fprintf(&b, "Package: p%d-%d\nVersion: 1.%d\n", i, level, level)
+			if level+1 < depth {
+				fmt.Fprintf(&b, "Depends: p%d-%d, shared\n\n", i, level+1)
+			} else {
+				b.WriteString("Depends: shared\n\n")
+			}
+		}
+	}
+	fmt.Fprintf(&b, "Package: shared\nVersion: 1.0\n\nPackage: root\nVersion: 1.0\nDepends: %s\n", strings.Join(rootDeps, ", "))
+	return b.String()
+}
+
+// graphShape возвращает отсортированные узлы и рёбра графа для сравнения построений
+func graphShape(graph *Graph) (nodes, edges []string) {
+	for name, node := range graph.Nodes {
+		nodes = append(nodes, name+"="+node.Version)
+		for _, dep := range graph.Edges[name] {
+			edges = append(edges, name+" -> "+dep)
+		}
+	}
+	sort.Strings(nodes)
+	sort.Strings(edges)
+	return nodes, edges
+}
+
+// TestConcurrentBuildMatchesSerial: параллельное построение даёт те же узлы, рёбра
+// и циклы, что и DFS (запускается и под go test -race)
+func TestConcurrentBuildMatchesSerial(t *testing.T) {
+	data, err := os.ReadFile(testRepo(t, "cyclic_graph.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := map[string]string{"wide": wideIndex(40, 4), "cyclic": string(data)}
+	roots := map[string]string{"wide": "root", "cyclic": "A"}
+
+	for name, index := range indexes {
+		t.Run(name, func(t *testing.T) {
+			serial := buildTestGraph(t, index, roots[name], "max_depth,10")
+			wantNodes, wantEdges := graphShape(serial)
+			for range 5 {
+				parallel := buildTestGraph(t, index, roots[name], "build_concurrency,8")
+				nodes, edges := graphShape(parallel)
+				if !slices.Equal(nodes, wantNodes) || !slices.Equal(edges, wantEdges) {
+					t.Fatalf("узлы/рёбра различаются:\n%v\n%v\nожидалось:\n%v\n%v", nodes, edges, wantNodes, wantEdges)
+				}
+				if !slices.Equal(parallel.Cycles, serial.Cycles) {
+					t.Fatalf("циклы %v, ожидались %v", parallel.Cycles, serial.Cycles)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBuildConcurrency сравнивает последовательное и параллельное построение
+// широкого графа: go test -bench BuildConcurrency -run '^$'
+func BenchmarkBuildConcurrency(b *testing.B) {
+	packages, _, err := parsePackagesFile(strings.NewReader(wideIndex(400, 8)), ParseOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("build_concurrency=%d", workers), func(b *testing.B) {
+			config := &Config{
+				PackageName: "root", TestMode: true, MaxDepth: 20,
+				BuildConcurrency: workers, Traversal: "dfs", DependencyKinds: []EdgeKind{KindDepends},
+				logOut: io.Discard, // Ход построения не нужен в результатах бенчмарка
+			}
+
+			for b.Loop() {
+				if _, err := buildGraphFromPackages(config, packages); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestCyclesOnlyInSection: циклы не печатаются при построении, а выводятся один раз
+// в разделе «Обнаруженные циклы»
+func TestCyclesOnlyInSection(t *testing.T) {
+	stdout, stderr, code := runWithConfig(t, "package_name,A", "repository_url,"+testRepo(t, "cyclic_graph.txt"),
+		"test_mode,true", "version,", "max_depth,5")
+	if code != ExitSuccess {
+		t.Fatalf("код завершения %d, stderr: %s", code, stderr)
+	}
+
+	section := strings.Index(stdout, "=== Обнаруженные циклы ===")
+	if section < 0 {
+		t.Fatalf("нет раздела циклов:\n%s", stdout)
+	}
+	const cycle = "A -> B -> D -> A"
+	if strings.Count(stdout, cycle) != 1 || strings.Index(stdout, cycle) < section {
+		t.Errorf("цикл %q должен встречаться один раз и только в разделе циклов:\n%s", cycle, stdout)
+	}
+	if strings.Contains(stdout[:section], " -> ") || strings.Contains(stderr, " -> ") {
+		t.Errorf("циклы выведены при построении:\n%s\n%s", stdout[:section], stderr)
+	}
+}
+
+// TestDiscoverRelease: discover перечисляет компоненты и архитектуры из файла Release
+func TestDiscoverRelease(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "dists/focal/Release",
+		"Origin: Ubuntu\nSuite: focal\nCodename: focal\n"+
+			"Architectures: amd64 arm64\nComponents: main universe\n"+
+			"SHA256:\n 0123 100 main/binary-amd64/Packages\n")
+
+	stdout, stderr, code := runAnalyzer(t, dir, "discover", filepath.Join(dir, "dists", "focal"))
+	if code != ExitSuccess {
+		t.Fatalf("код завершения %d, stderr: %s", code, stderr)
+	}
+	for _, want := range []string{
+		"Компоненты: main, universe",
+		"Архитектуры: amd64, arm64",
+		"/dists/focal/universe/binary-arm64/Packages.gz",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("нет %q в выводе:\n%s", want, stdout)
+		}
+	}
+
+	release, err := parseReleaseFile(strings.NewReader("Components: main universe\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(release.Components, []string{"main", "universe"}) {
+		t.Errorf("компоненты: %v", release.Components)
+	}
+}
+
+// TestDuplicateDependencyEdge: зависимость, указанная дважды, даёт одно ребро
+func TestDuplicateDependencyEdge(t *testing.T) {
+	index := "Package: app\nVersion: 1.0\nDepends: foo, bar, foo (>= 1.0)\n\n" +
+		"Package: foo\nVersion: 1.0\n\nPackage: bar\nVersion: 1.0\n"
+	graph := buildTestGraph(t, index, "app")
+	if got := graph.Edges["app"]; !slices.Equal(got, []string{"foo", "bar"}) {
+		t.Errorf("рёбра app: %v, ожидалось [foo bar]", got)
+	}
+	if graph.EdgeCount() != 2 {
+		t.Errorf("EdgeCount() = %d, ожидалось 2", graph.EdgeCount())
+	}
+}
+
+// TestLongestPath: самая длинная цепочка находится, даже если обход первой
+// встречает более короткую ветвь
+func TestLongestPath(t *testing.T) {
+	index := "Package: A\nVersion: 1.0\nDepends: B, C\n\n" +
+		"Package: B\nVersion: 1.0\nDepends: X\n\n" +
+		"Package: C\nVersion: 1.0\nDepends: D\n\n" +
+		"Package: D\nVersion: 1.0\nDepends: E, A\n\n" +
+		"Package: E\nVersion: 1.0\n\nPackage: X\nVersion: 1.0\n"
+	for _, traversal := range []string{"dfs", "bfs"} {
+		graph := buildTestGraph(t, index, "A", "traversal,"+traversal)
+		if got, want := graph.LongestPath(), []string{"A", "C", "D", "E"}; !slices.Equal(got, want) {
+			t.Errorf("%s: LongestPath() = %v, ожидалось %v", traversal, got, want)
+		}
+	}
+}
+
+// TestConfigInclude: значения подключённой базовой конфигурации используются,
+// если переопределяющий файл их не задаёт; включение ищется относительно файла
+func TestConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "base/base.csv",
+		"repository_url,"+testRepo(t, "simple_graph.txt")+"\ntest_mode,true\nversion,\nmax_depth,2\nindent_width,4\n")
+	filename := writeTestFile(t, dir, "override.csv", "include,base/base.csv\npackage_name,A\nmax_depth,5\n")
+
+	config, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if config.MaxDepth != 5 {
+		t.Errorf("max_depth = %d, ожидалось значение переопределяющего файла 5", config.MaxDepth)
+	}
+	if config.IndentWidth != 4 || !config.TestMode {
+		t.Errorf("значения базового файла не применены: indent_width=%d test_mode=%v", config.IndentWidth, config.TestMode)
+	}
+
+	writeTestFile(t, dir, "loop.csv", "include,override.csv\n")
+	writeTestFile(t, dir, "override.csv", "include,loop.csv\npackage_name,A\n")
+	if _, err := LoadConfig(filename); err == nil || !strings.Contains(err.Error(), "циклическое подключение") {
+		t.Errorf("ожидалась ошибка циклического подключения, получено: %v", err)
+	}
+}
+
+// TestHideEpoch: эпоха скрывается только при выводе, сравнение версий её учитывает
+func TestHideEpoch(t *testing.T) {
+	config := &Config{HideEpoch: true}
+	if got := displayVersion("1:2.30-0ubuntu2", config); got != "2.30-0ubuntu2" {
+		t.Errorf("displayVersion() = %q", got)
+	}
+	if got := displayVersion("1:2.30", &Config{}); got != "1:2.30" {
+		t.Errorf("без hide_epoch версия изменена: %q", got)
+	}
+	if compareDebianVersions("1:1.0", "2.0") <= 0 {
+		t.Error("версия с эпохой 1:1.0 должна быть новее 2.0")
+	}
+
+	// Список версий упорядочен с учётом эпохи, а выводится без неё
+	versions := []Package{{Name: "lib", Version: "3.0"}, {Name: "lib", Version: "1:1.0"}}
+	var out bytes.Buffer
+	printPackageVersions(&out, "lib", versions, "", config)
+	if !strings.Contains(out.String(), "  1. 1.0\n  2. 3.0\n") {
+		t.Errorf("версии должны быть упорядочены с учётом эпохи и выведены без неё:\n%s", out.String())
+	}
+}
+
+// TestRecommendsDelta: лист, достижимый только по Recommends, попадает в разницу,
+// а достижимый и по Depends — нет
+func TestRecommendsDelta(t *testing.T) {
+	index := "Package: app\nVersion: 1.0\nDepends: lib\nRecommends: extra, lib\n\n" +
+		"Package: lib\nVersion: 1.0\nRecommends: docs\n\n" +
+		"Package: extra\nVersion: 2.0\n\nPackage: docs\nVersion: 3.0\n"
+	graph := buildTestGraph(t, index, "app")
+
+	if hard := graph.dependencyClosure(false); len(hard) != 2 || !hard["lib"] {
+		t.Errorf("замыкание по Depends: %v", hard)
+	}
+	var out bytes.Buffer
+	printRecommendsDelta(&out, graph)
+	for _, want := range []string{"Добавляется только через Recommends: 2", "  - docs [3.0]", "  - extra [2.0]"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("нет %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "  - lib") {
+		t.Errorf("lib достижим по Depends и не должен попасть в разницу:\n%s", out.String())
+	}
+}
+
+// TestCollapseRepeats: повторное поддерево сворачивается в строку с числом
+// транзитивных зависимостей
+func TestCollapseRepeats(t *testing.T) {
+	index := "Package: app\nVersion: 1.0\nDepends: left, right\n\n" +
+		"Package: left\nVersion: 1.0\nDepends: shared\n\n" +
+		"Package: right\nVersion: 1.0\nDepends: shared\n\n" +
+		"Package: shared\nVersion: 1.0\nDepends: x, y\n\n" +
+		"Package: x\nVersion: 1.0\nDepends: z\n\n" +
+		"Package: y\nVersion: 1.0\n\nPackage: z\nVersion: 1.0\n"
+	config := loadTestConfig(t, index, "app", "collapse_repeats,true")
+	graph := buildTestGraph(t, index, "app")
+
+	var out bytes.Buffer
+	printGraph(&out, graph, config)
+	if got := strings.Count(out.String(), "- shared [1.0] (+3 транзитивных)"); got != 1 {
+		t.Errorf("свёрнутых поддеревьев shared: %d, ожидалось 1:\n%s", got, out.String())
+	}
+	if got := strings.Count(out.String(), "- z [1.0]"); got != 1 {
+		t.Errorf("поддерево shared раскрыто %d раз:\n%s", got, out.String())
+	}
+}
+
+// TestDpkgStatusIndex: при index_type=status учитываются только установленные пакеты
+func TestDpkgStatusIndex(t *testing.T) {
+	status := "Package: app\nStatus: install ok installed\nVersion: 1.0\nDepends: lib, removed\n\n" +
+		"Package: lib\nStatus: install ok installed\nVersion: 2.0\n\n" +
+		"Package: removed\nStatus: deinstall ok config-files\nVersion: 3.0\n"
+	graph := buildTestGraph(t, status, "app", "index_type,status", "test_mode,false")
+
+	if node := graph.Nodes["lib"]; node == nil || node.Version != "2.0" {
+		t.Errorf("установленный пакет lib: %+v", node)
+	}
+	if node := graph.Nodes["removed"]; node == nil || node.Version != "unknown" {
+		t.Errorf("неустановленный пакет должен считаться отсутствующим: %+v", node)
+	}
+	if _, exists := graph.PackageSource["removed"]; exists {
+		t.Error("неустановленный пакет попал в индекс")
+	}
+}
+
+// TestPinsForceOlderVersion: закрепление выбирает более старую версию вместо новейшей,
+// а отсутствующая закреплённая версия — ошибка
+func TestPinsForceOlderVersion(t *testing.T) {
+	index := "Package: app\nVersion: 1.0\nDepends: lib\n\n" +
+		"Package: lib\nVersion: 2.0\n\nPackage: lib\nVersion: 1.5\n"
+
+	if got := buildTestGraph(t, index, "app").Nodes["lib"].Version; got != "2.0" {
+		t.Fatalf("без закрепления выбрана %s, ожидалась новейшая 2.0", got)
+	}
+	if got := buildTestGraph(t, index, "app", "pins,lib=1.5").Nodes["lib"].Version; got != "1.5" {
+		t.Errorf("с pins выбрана %s, ожидалась 1.5", got)
+	}
+
+	config := loadTestConfig(t, index, "app", "pins,lib=9.9")
+	var err error
+	captureOutput(t, func() { _, err = buildDependencyGraph(config) })
+	if exitCodeFor(err) != ExitNotFound {
+		t.Errorf("для отсутствующей закреплённой версии ожидался код %d: %v", ExitNotFound, err)
+	}
+}
+
+// TestCycleReport: output_format=cycles выводит только циклы (или сообщение об их отсутствии)
+func TestCycleReport(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    string
+	}{
+		{"cyclic_graph.txt", "1. A -> B -> D -> A\n"},
+		{"simple_graph.txt", "циклы не обнаружены\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(testRepo(t, tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			printCycleReport(&out, buildTestGraph(t, string(data), "A"))
+			if out.String() != tt.want {
+				t.Errorf("получено %q, ожидалось %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+// TestSectionFilter: пакеты других разделов остаются в графе листьями
+func TestSectionFilter(t *testing.T) {
+	index := "Package: app\nVersion: 1.0\nSection: net\nDepends: netlib, doc\n\n" +
+		"Package: netlib\nVersion: 1.0\nSection: net\nDepends: base\n\n" +
+		"Package: doc\nVersion: 1.0\nSection: doc\nDepends: viewer\n\n" +
+		"Package: base\nVersion: 1.0\nSection: net\n\n" +
+		"Package: viewer\nVersion: 1.0\nSection: doc\n"
+	graph := buildTestGraph(t, index, "app", "section_filter,net")
+
+	if node := graph.Nodes["doc"]; node == nil || !node.Pruned {
+		t.Fatalf("пакет другого раздела должен быть нераскрытым листом: %+v", node)
+	}
+	if _, exists := graph.Nodes["viewer"]; exists {
+		t.Error("зависимость пакета другого раздела раскрыта")
+	}
+	if _, exists := graph.Nodes["base"]; !exists {
+		t.Error("зависимость пакета раздела net не раскрыта")
+	}
+}
+
+// TestSearchPackages: search находит пакеты по префиксному шаблону, отсортированными по имени
+func TestSearchPackages(t *testing.T) {
+	index := "Package: libcurl4\nVersion: 7.68\n\nPackage: curl\nVersion: 7.68\n\n" +
+		"Package: libcurl3-gnutls\nVersion: 7.58\n\nPackage: libssl1.1\nVersion: 1.1\n"
+	for _, tt := range []struct {
+		pattern string
+		extra   []string
+	}{
+		{"libcurl*", nil},
+		{"^libcurl", []string{"search_regex,true"}},
+	} {
+		config := loadTestConfig(t, index, "curl", tt.extra...)
+		var err error
+		stdout, _ := captureOutput(t, func() { err = searchPackages(tt.pattern, config) })
+		if err != nil {
+			t.Fatalf("%s: searchPackages: %v", tt.pattern, err)
+		}
+		want := "  libcurl3-gnutls [7.58]\n  libcurl4 [7.68]\n\nНайдено совпадений: 2\n"
+		if !strings.HasSuffix(stdout, want) {
+			t.Errorf("%s: ожидалось\n%s\nполучено:\n%s", tt.pattern, want, stdout)
+		}
+	}
+}
+
+// TestDOTEdgeWeights: ребро Depends толще и тяжелее ребра Recommends
+func TestDOTEdgeWeights(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B\nRecommends: C\n\n" +
+		"Package: B\nVersion: 1\n\nPackage: C\nVersion: 1\n"
+	config := loadTestConfig(t, index, "A", `dependency_kinds,"depends,recommends"`)
+	var graph *Graph
+	var err error
+	captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+
+	dot := generateGraphvizDOT(graph, config)
+	for _, want := range []string{
+		`"A" -> "B" [penwidth=2, weight=5, style=solid];`,
+		`"A" -> "C" [penwidth=1, weight=2, style=dashed];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("в DOT нет строки %s:\n%s", want, dot)
+		}
+	}
+}
+
+// TestProgressEvents: ProgressFunc получает разбор индекса, посещения узлов
+// в порядке обхода и найденный цикл
+func TestProgressEvents(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B\n\nPackage: B\nVersion: 2\nDepends: A\n\nPackage: X\nVersion: 1\n"
+	config := loadTestConfig(t, index, "A")
+	var events []ProgressEvent
+	config.ProgressFunc = func(event ProgressEvent) { events = append(events, event) }
+
+	var err error
+	captureOutput(t, func() { _, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+
+	want := []ProgressEvent{
+		PackagesParsed{Count: 3},
+		NodeVisited{Name: "A", Version: "1", Depth: 0},
+		NodeVisited{Name: "B", Version: "2", Depth: 1},
+		CycleFound{Path: "A -> B -> A"},
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("события: %#v, ожидалось %#v", events, want)
+	}
+}
+
+// TestMissingPackageSuggestions: опечатка в имени даёт подсказку с ближайшими именами
+func TestMissingPackageSuggestions(t *testing.T) {
+	var packages []Package
+	for _, name := range []string{"nginx", "nginx-common", "ngircd", "libnginx-mod-http", "curl"} {
+		packages = append(packages, Package{Name: name, Version: "1"})
+	}
+
+	_, err := findPackage(packages, "ngnix", "", false, false)
+	if err == nil {
+		t.Fatal("ожидалась ошибка для несуществующего пакета")
+	}
+	if code := exitCodeFor(err); code != ExitNotFound {
+		t.Errorf("код выхода %d, ожидался %d", code, ExitNotFound)
+	}
+	if want := "возможно, вы имели в виду: nginx"; !strings.Contains(err.Error(), want) {
+		t.Errorf("ошибка %q не содержит %q", err, want)
+	}
+
+	if got := suggestPackageNames(packages, "zzzzzz"); len(got) != 0 {
+		t.Errorf("для далёкого имени не должно быть подсказок, получено %v", got)
+	}
+	for i := range 5 {
+		packages = append(packages, Package{Name: fmt.Sprintf("ngni%d", i)})
+	}
+	if got := suggestPackageNames(packages, "ngnix"); len(got) != maxSuggestions {
+		t.Errorf("подсказок %d (%v), ожидалось не больше %d", len(got), got, maxSuggestions)
+	}
+}
+
+// TestPrintRoot: print_root печатает поддерево выбранного узла по уже построенному графу
+func TestPrintRoot(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B, C\n\nPackage: B\nVersion: 2\nDepends: D\n\n" +
+		"Package: C\nVersion: 3\n\nPackage: D\nVersion: 4\n"
+	graph := buildTestGraph(t, index, "A", "print_root,B")
+	config := loadTestConfig(t, index, "A", "print_root,B")
+
+	var out bytes.Buffer
+	printGraph(&out, graph, config)
+	want := "\n=== Граф зависимостей ===\n- B [2] (depth: 1) [прямая]\n  - D [4] (depth: 2)\n"
+	if out.String() != want {
+		t.Errorf("ожидалось поддерево B:\n%s\nполучено:\n%s", want, out.String())
+	}
+}
+
+// TestGraphRootsAndLeaves: Roots — узлы без входящих рёбер, Leaves — без исходящих
+func TestGraphRootsAndLeaves(t *testing.T) {
+	graph := &Graph{
+		Nodes: map[string]*Node{"A": {Name: "A"}, "B": {Name: "B"}, "C": {Name: "C"}, "D": {Name: "D"}, "E": {Name: "E"}},
+		Edges: map[string][]string{
+			"A": {"C"},
+			"B": {"C", "missing"},
+			"C": {"D"},
+		},
+	}
+
+	if got, want := graph.Roots(), []string{"A", "B", "E"}; !slices.Equal(got, want) {
+		t.Errorf("Roots() = %v, ожидалось %v", got, want)
+	}
+	if got, want := graph.Leaves(), []string{"D", "E"}; !slices.Equal(got, want) {
+		t.Errorf("Leaves() = %v, ожидалось %v", got, want)
+	}
+}
+
+// TestBoundaryPackages: пакет-граница попадает в граф листом, его зависимости не раскрываются
+func TestBoundaryPackages(t *testing.T) {
+	const index = "Package: app\nVersion: 1\nDepends: svc, util\n\nPackage: svc\nVersion: 1\nDepends: core\n\n" +
+		"Package: util\nVersion: 1\n\nPackage: core\nVersion: 1\nDepends: libc\n\nPackage: libc\nVersion: 1\n"
+	for _, traversal := range []string{"dfs", "bfs"} {
+		t.Run(traversal, func(t *testing.T) {
+			graph := buildTestGraph(t, index, "app", "traversal,"+traversal, `boundary_packages,"svc, other"`)
+			for _, name := range []string{"app", "svc", "util"} {
+				if _, ok := graph.Nodes[name]; !ok {
+					t.Errorf("узел %s должен быть в графе", name)
+				}
+			}
+			for _, name := range []string{"core", "libc"} {
+				if _, ok := graph.Nodes[name]; ok {
+					t.Errorf("узел %s лежит за границей svc и не должен попасть в граф", name)
+				}
+			}
+			if node := graph.Nodes["svc"]; node != nil && !node.Pruned {
+				t.Error("граница svc должна быть помечена как нераскрытая")
+			}
+		})
+	}
+}
+
+// TestRenderDepth: граф строится до глубины 5, а дерево печатается только до уровня 2
+func TestRenderDepth(t *testing.T) {
+	var index strings.Builder
+	names := []string{"A", "B", "C", "D", "E", "F"}
+	for i, name := range names {
+		fmt.Fprintf(&index, "Package: %s\nVersion: 1\n", name)
+		if i+1 < len(names) {
+			fmt.Fprintf(&index, "Depends: %s\n", names[i+1])
+		}
+		index.WriteString("\n")
+	}
+	extra := []string{"max_depth,5", "render_depth,2"}
+	graph := buildTestGraph(t, index.String(), "A", extra...)
+	if _, ok := graph.Nodes["F"]; !ok {
+		t.Fatalf("граф должен содержать узел глубины 5, узлы: %v", slices.Sorted(maps.Keys(graph.Nodes)))
+	}
+
+	var out bytes.Buffer
+	printGraph(&out, graph, loadTestConfig(t, index.String(), "A", extra...))
+	tree := out.String()
+	for _, name := range []string{"A", "B", "C"} {
+		if !strings.Contains(tree, "- "+name+" [") {
+			t.Errorf("узел %s должен быть в дереве:\n%s", name, tree)
+		}
+	}
+	for _, name := range []string{"D", "E", "F"} {
+		if strings.Contains(tree, "- "+name+" [") {
+			t.Errorf("узел %s глубже render_depth:\n%s", name, tree)
+		}
+	}
+}
+
+// TestBlacklistReport: транзитивный запрещённый пакет выводится с цепочкой от корня
+func TestBlacklistReport(t *testing.T) {
+	const index = "Package: app\nVersion: 1\nDepends: web\n\nPackage: web\nVersion: 1\nDepends: ssl\n\n" +
+		"Package: ssl\nVersion: 1\nDepends: oldcrypto\n\nPackage: oldcrypto\nVersion: 1\n"
+	extra := []string{`blacklist,"oldcrypto, telnet"`}
+	graph := buildTestGraph(t, index, "app", extra...)
+	config := loadTestConfig(t, index, "app", extra...)
+
+	var found int
+	stdout, _ := captureOutput(t, func() { found = printBlacklistReport(graph, config) })
+	if found != 1 {
+		t.Errorf("найдено %d запрещённых пакетов, ожидался 1", found)
+	}
+	if want := "- oldcrypto: app -> web -> ssl -> oldcrypto\n"; !strings.Contains(stdout, want) {
+		t.Errorf("в отчёте нет строки %q:\n%s", want, stdout)
+	}
+	if strings.Contains(stdout, "telnet") {
+		t.Errorf("отсутствующий в графе telnet не должен попасть в отчёт:\n%s", stdout)
+	}
+}
+
+// TestSummaryLine: output_format=summary печатает в stdout одну строку key=value
+func TestSummaryLine(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "Packages", `Package: bash
+Version: 5.1
+Depends: libc6, libtinfo6, ghost
+
+Package: libc6
+Version: 2.31
+Depends: bash
+
+Package: libtinfo6
+Version: 6.2
+Depends: libc6, ncurses-base
+
+Package: ncurses-base
+Version: 6.2
+`)
+	writeTestConfig(t, dir, "package_name,bash", "repository_url,Packages", "test_mode,true", "version,", "max_depth,5",
+		"output_format,summary")
+
+	stdout, stderr, code := runAnalyzer(t, dir, "config.csv")
+	if code != ExitSuccess {
+		t.Fatalf("код выхода %d, stderr:\n%s", code, stderr)
+	}
+	if want := "root=bash version=5.1 nodes=5 edges=6 cycles=1 missing=1 depth=2\n"; stdout != want {
+		t.Errorf("stdout = %q, ожидалось %q", stdout, want)
+	}
+}
+
+// serveTestDNS отвечает на A-запросы адресом 127.0.0.1 (на остальные — пустым
+// ответом) и возвращает адрес сервера и канал запрошенных имён
+func serveTestDNS(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	queried := make(chan string, 16)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := buf[:n]
+			// Вопрос: имя из меток, затем тип и класс
+			end := 12
+			var labels []string
+			for end < n && query[end] != 0 {
+				labels = append(labels, string(query[end+1:end+1+int(query[end])]))
+				end += 1 + int(query[end])
+			}
+			end += 5
+			if end > n {
+				continue
+			}
+			qtype := binary.BigEndian.Uint16(query[end-4:])
+
+			response := append([]byte{}, query[:end]...)
+			response[2], response[3] = 0x81, 0x80 // ответ, рекурсия доступна, NOERROR
+			binary.BigEndian.PutUint16(response[6:], 0)
+			if qtype == 1 {
+				select {
+				case queried <- strings.Join(labels, "."):
+				default:
+				}
+				binary.BigEndian.PutUint16(response[6:], 1)
+				response = append(response, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+			}
+			conn.WriteTo(response, addr)
+		}
+	}()
+	return conn.LocalAddr().String(), queried
+}
+
+// TestDNSServer: имя зеркала разрешается через заданный dns_server, IPv6-адреса
+// принимаются как в скобках, так и без
+func TestDNSServer(t *testing.T) {
+	const index = "Package: A\nVersion: 1.0\n"
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, index)
+	}))
+	defer mirror.Close()
+	_, port, _ := net.SplitHostPort(mirror.Listener.Addr().String())
+
+	dnsAddress, queried := serveTestDNS(t)
+	reader, err := fetchPackagesFile("http://mirror.resolver-test:"+port+"/Packages", &Config{DNSServer: dnsAddress})
+	if err != nil {
+		t.Fatalf("fetchPackagesFile: %v", err)
+	}
+	defer reader.(io.Closer).Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != index {
+		t.Errorf("получено %q", data)
+	}
+	select {
+	case name := <-queried:
+		if name != "mirror.resolver-test" {
+			t.Errorf("через dns_server разрешалось имя %q", name)
+		}
+	default:
+		t.Error("имя зеркала не разрешалось через dns_server")
+	}
+
+	for _, tt := range []struct{ value, want string }{
+		{"10.0.0.1", "10.0.0.1:53"},
+		{"10.0.0.1:5353", "10.0.0.1:5353"},
+		{"::1", "[::1]:53"},
+		{"[::1]", "[::1]:53"},
+		{"[::1]:5353", "[::1]:5353"},
+	} {
+		if got, err := normalizeDNSServer(tt.value); err != nil || got != tt.want {
+			t.Errorf("normalizeDNSServer(%q) = %q, %v; ожидалось %q", tt.value, got, err, tt.want)
+		}
+	}
+	if _, err := normalizeDNSServer("fe80::zz"); err == nil {
+		t.Error("для неверного адреса ожидалась ошибка")
+	}
+}
+
+// TestVerifyChecksum: индекс сверяется с SHA256 из Release в корне dists/<suite>
+func TestVerifyChecksum(t *testing.T) {
+	const index = "Package: A\nVersion: 1.0\n"
+	sum := sha256.Sum256([]byte(index))
+	for _, tt := range []struct {
+		name     string
+		checksum string
+		wantErr  bool
+	}{
+		{"совпадает", hex.EncodeToString(sum[:]), false},
+		{"не совпадает", strings.Repeat("0", 64), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			release := "Suite: stable\nSHA256:\n " + tt.checksum + " " + strconv.Itoa(len(index)) + " main/binary-amd64/Packages\n"
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/debian/dists/stable/Release":
+					io.WriteString(w, release)
+				case "/debian/dists/stable/main/binary-amd64/Packages":
+					io.WriteString(w, index)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			config := &Config{RepositoryURL: server.URL + "/debian/dists/stable/main/binary-amd64/Packages", VerifyChecksum: true}
+			var packages []Package
+			var err error
+			captureOutput(t, func() { packages, err = loadSourcePackages(config) })
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "не совпадает") {
+					t.Fatalf("ожидалась ошибка несовпадения, получено %v", err)
+				}
+				if code := exitCodeFor(err); code != ExitFetchError {
+					t.Errorf("код выхода %d, ожидался %d", code, ExitFetchError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadSourcePackages: %v", err)
+			}
+			if len(packages) != 1 || packages[0].Name != "A" {
+				t.Errorf("разобраны пакеты %v", packages)
+			}
+		})
+	}
+}
+
+// TestCompareArchitectures: граф строится для каждой архитектуры, лишняя
+// зависимость одной из них попадает в отчёт о различиях
+func TestCompareArchitectures(t *testing.T) {
+	dir := t.TempDir()
+	for arch, index := range map[string]string{
+		"amd64": "Package: app\nVersion: 1\nDepends: libc\n\nPackage: libc\nVersion: 2\n",
+		"arm64": "Package: app\nVersion: 1\nDepends: libc, libatomic\n\nPackage: libc\nVersion: 2\n\nPackage: libatomic\nVersion: 3\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, "binary-"+arch), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, dir, filepath.Join("binary-"+arch, "Packages"), index)
+	}
+	writeTestConfig(t, dir, "package_name,app", "repository_url,binary-amd64/Packages", "test_mode,true", "version,",
+		"max_depth,5", `architectures,"amd64,arm64"`)
+
+	stdout, stderr, code := runAnalyzer(t, dir, "config.csv")
+	if code != ExitSuccess {
+		t.Fatalf("код выхода %d, stderr:\n%s", code, stderr)
+	}
+	_, report, found := strings.Cut(stdout, "=== Сравнение архитектур")
+	if !found {
+		t.Fatalf("нет отчёта о сравнении:\n%s", stdout)
+	}
+	for _, want := range []string{
+		"amd64: 2 пакетов\n",
+		"arm64: 3 пакетов\n",
+		"  libatomic: amd64=отсутствует arm64=3\n",
+		"Различающихся пакетов: 1\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("в отчёте нет %q:\n%s", want, report)
+		}
+	}
+}
+
+// TestReplacesResolution: отсутствующая зависимость разрешается пакетом с Replaces,
+// о замене сообщается, а Provides имеет приоритет
+func TestReplacesResolution(t *testing.T) {
+	const index = `Package: app
+Version: 1
+Depends: oldtool, oldlib
+
+Package: newtool
+Version: 2.0
+Replaces: oldtool (<< 2.0)
+Depends: libx
+
+Package: libx
+Version: 1
+
+Package: libnew-replaces
+Version: 3
+Replaces: oldlib
+
+Package: libnew-provides
+Version: 4
+Provides: oldlib
+`
+	config := loadTestConfig(t, index, "app")
+	var graph *Graph
+	var err error
+	stdout, _ := captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+
+	node := graph.Nodes["oldtool"]
+	if node == nil || node.ProvidedBy != "newtool" || node.Version != "2.0" {
+		t.Fatalf("oldtool должен разрешаться через newtool [2.0], получено %+v", node)
+	}
+	if _, ok := graph.Nodes["libx"]; !ok {
+		t.Error("зависимости заменяющего пакета должны раскрываться")
+	}
+	if want := "зависимость oldtool разрешена пакетом newtool [2.0] (Replaces)"; !strings.Contains(stdout, want) {
+		t.Errorf("нет сообщения о замене %q:\n%s", want, stdout)
+	}
+	if node := graph.Nodes["oldlib"]; node == nil || node.ProvidedBy != "libnew-provides" {
+		t.Errorf("Provides должен иметь приоритет над Replaces, получено %+v", node)
+	}
+}
+
+// TestEdgeKindCounts: рёбра смешанного графа подсчитываются по типам зависимостей
+// и выводятся в статистике построения
+func TestEdgeKindCounts(t *testing.T) {
+	const index = `Package: A
+Version: 1
+Pre-Depends: P
+Depends: B, C
+Recommends: R
+Suggests: S
+
+Package: B
+Version: 1
+Depends: C
+Recommends: R
+
+Package: C
+Version: 1
+
+Package: P
+Version: 1
+
+Package: R
+Version: 1
+Suggests: S
+
+Package: S
+Version: 1
+`
+	config := loadTestConfig(t, index, "A", `dependency_kinds,"pre-depends,depends,recommends,suggests"`)
+	var graph *Graph
+	var err error
+	stdout, _ := captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+
+	want := map[EdgeKind]int{KindPreDepends: 1, KindDepends: 3, KindRecommends: 2, KindSuggests: 2}
+	if got := graph.EdgeKindCounts(); !maps.Equal(got, want) {
+		t.Errorf("EdgeKindCounts() = %v, ожидалось %v", got, want)
+	}
+	for _, line := range []string{"  - Рёбер Pre-Depends: 1\n", "  - Рёбер Depends: 3\n", "  - Рёбер Recommends: 2\n", "  - Рёбер Suggests: 2\n"} {
+		if !strings.Contains(stdout, line) {
+			t.Errorf("в статистике нет строки %q:\n%s", line, stdout)
+		}
+	}
+}
+
+// TestMinPackages: усечённый при загрузке индекс даёт меньше min_packages пакетов и отвергается
+func TestMinPackages(t *testing.T) {
+	var full strings.Builder
+	for i := range 5 {
+		fmt.Fprintf(&full, "Package: pkg%d\nVersion: 1.%d\nDepends: libc6\n\n", i, i)
+	}
+	// Обрыв соединения посреди третьей записи
+	truncated := full.String()[:strings.Index(full.String(), "Package: pkg2")+len("Package: pkg2\nVers")]
+
+	for _, tt := range []struct {
+		name    string
+		index   string
+		wantErr bool
+	}{
+		{"полный", full.String(), false},
+		{"усечённый", truncated, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := loadTestConfig(t, tt.index, "pkg0", "min_packages,5")
+			var err error
+			captureOutput(t, func() { _, err = loadPackages(config) })
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("loadPackages: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "подозрительно мало пакетов: 3 (min_packages=5)") {
+				t.Fatalf("ожидалась ошибка о малом числе пакетов, получено %v", err)
+			}
+			if code := exitCodeFor(err); code != ExitParseError {
+				t.Errorf("код выхода %d, ожидался %d", code, ExitParseError)
+			}
+		})
+	}
+}
+
+// TestDirectDependencies: прямыми считаются зависимости корня, даже если DFS
+// впервые встречает их глубже; признак попадает в дерево и в jsonl
+func TestDirectDependencies(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B, C\n\nPackage: B\nVersion: 1\nDepends: C, D\n\n" +
+		"Package: C\nVersion: 1\n\nPackage: D\nVersion: 1\n"
+	for _, traversal := range []string{"dfs", "bfs"} {
+		t.Run(traversal, func(t *testing.T) {
+			graph := buildTestGraph(t, index, "A", "traversal,"+traversal)
+			for name, want := range map[string]bool{"A": false, "B": true, "C": true, "D": false} {
+				if got := graph.Nodes[name].IsDirect; got != want {
+					t.Errorf("%s: IsDirect = %v, ожидалось %v", name, got, want)
+				}
+			}
+		})
+	}
+
+	config := loadTestConfig(t, index, "A")
+	graph := buildTestGraph(t, index, "A")
+	var tree bytes.Buffer
+	printGraph(&tree, graph, config)
+	if !strings.Contains(tree.String(), "- B [1] (depth: 1) [прямая]") || strings.Contains(tree.String(), "- D [1] (depth: 2) [прямая]") {
+		t.Errorf("в дереве должны быть отмечены только прямые зависимости:\n%s", tree.String())
+	}
+	if record := newNodeRecord(graph.Nodes["D"], config); record.Direct {
+		t.Error("D — транзитивная зависимость, direct должен быть false")
+	}
+	if record := newNodeRecord(graph.Nodes["C"], config); !record.Direct {
+		t.Error("C — прямая зависимость, direct должен быть true")
+	}
+}
+
+// TestRootGlob: шаблон package_name раскрывается в два корня, каждый со своим графом;
+// слишком широкий шаблон ограничивается max_roots
+func TestRootGlob(t *testing.T) {
+	const index = "Package: python3-yaml\nVersion: 6.0\nDepends: python3\n\nPackage: python3-six\nVersion: 1.16\nDepends: python3\n\n" +
+		"Package: python3\nVersion: 3.11\n\nPackage: perl\nVersion: 5.36\n"
+	config := loadTestConfig(t, index, "python3-*", "root_glob,true")
+	var graphs []*Graph
+	var err error
+	stdout, _ := captureOutput(t, func() { graphs, err = buildRootGraphs(config) })
+	if err != nil {
+		t.Fatalf("buildRootGraphs: %v", err)
+	}
+
+	var roots []string
+	for _, graph := range graphs {
+		roots = append(roots, graph.Root)
+		if _, ok := graph.Nodes["python3"]; !ok {
+			t.Errorf("граф %s должен содержать python3", graph.Root)
+		}
+	}
+	if want := []string{"python3-six", "python3-yaml"}; !slices.Equal(roots, want) {
+		t.Errorf("корни %v, ожидалось %v", roots, want)
+	}
+	if !strings.Contains(stdout, "Шаблон python3-*: корней 2 (python3-six, python3-yaml)") {
+		t.Errorf("нет сообщения о раскрытии шаблона:\n%s", stdout)
+	}
+
+	config = loadTestConfig(t, index, "p*", "root_glob,true", "max_roots,3")
+	captureOutput(t, func() { _, err = buildRootGraphs(config) })
+	if err == nil || exitCodeFor(err) != ExitConfigError {
+		t.Errorf("шаблон на 4 пакета при max_roots=3 должен давать ошибку конфигурации, получено %v", err)
+	}
+}
+
+// TestPprofFile: при заданном pprof_file создаются CPU-профиль и профиль памяти
+func TestPprofFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "Packages", "Package: A\nVersion: 1\nDepends: B\n\nPackage: B\nVersion: 1\n")
+	writeTestConfig(t, dir, "package_name,A", "repository_url,Packages", "test_mode,true", "version,", "max_depth,5",
+		"pprof_file,build.prof")
+
+	if _, stderr, code := runAnalyzer(t, dir, "config.csv"); code != ExitSuccess {
+		t.Fatalf("код выхода %d, stderr:\n%s", code, stderr)
+	}
+	for _, name := range []string{"build.prof", "build.prof.mem"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("профиль не создан: %v", err)
+		}
+		// Профили pprof сжаты gzip
+		if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+			t.Errorf("%s не похож на профиль pprof (%d байт)", name, len(data))
+		}
+	}
+
+	stop, err := startProfiling(io.Discard, "")
+	if err != nil {
+		t.Fatalf("startProfiling без файла: %v", err)
+	}
+	stop()
+}
+
+// TestEmptyDependencyTokens: пустые элементы списка зависимостей не превращаются в узлы
+func TestEmptyDependencyTokens(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{",", nil},
+		{"foo,", []string{"foo"}},
+		{", bar", []string{"bar"}},
+		{"foo, , bar,,", []string{"foo", "bar"}},
+		{"| baz (>= 1), qux", []string{"baz", "qux"}},
+	} {
+		got, invalid := parseDependencies(tt.value, ParseOptions{})
+		if !slices.Equal(got, tt.want) || len(invalid) != 0 {
+			t.Errorf("parseDependencies(%q) = %q, %q; ожидалось %q", tt.value, got, invalid, tt.want)
+		}
+	}
+
+	const index = "Package: A\nVersion: 1\nDepends: \nRecommends: ,\n\nPackage: B\nVersion: 1\nDepends: A,\n\n" +
+		"Package: C\nVersion: 1\nDepends: , B\n"
+	graph := buildTestGraph(t, index, "C", `dependency_kinds,"depends,recommends"`)
+	if got := slices.Sorted(maps.Keys(graph.Nodes)); !slices.Equal(got, []string{"A", "B", "C"}) {
+		t.Errorf("узлы графа %q, ожидалось A, B, C без пустых имён", got)
+	}
+}
+
+// TestNodePaths: для узла глубины 2 печатается кратчайший путь от корня,
+// даже если DFS впервые дошёл до него длинным путём
+func TestNodePaths(t *testing.T) {
+	const index = `Package: bash
+Version: 5.1
+Depends: base-files, libc6
+
+Package: base-files
+Version: 12
+Depends: mawk
+
+Package: mawk
+Version: 1.3
+Depends: libgcc-s1
+
+Package: libc6
+Version: 2.36
+Depends: libgcc-s1
+
+Package: libgcc-s1
+Version: 12
+`
+	graph := buildTestGraph(t, index, "bash", "traversal,dfs")
+	var out bytes.Buffer
+	printNodePaths(&out, graph)
+
+	want := "path: bash\npath: bash/base-files\npath: bash/libc6\npath: bash/base-files/mawk\npath: bash/libc6/libgcc-s1\n"
+	if out.String() != want {
+		t.Errorf("получено:\n%s\nожидалось:\n%s", out.String(), want)
+	}
+}
+
+// TestBySection: узлы группируются по секциям и сортируются внутри группы,
+// пакеты без секции выводятся последними
+func TestBySection(t *testing.T) {
+	const index = `Package: app
+Version: 1
+Section: utils
+Depends: zlib1g, libc6, tar, ghost
+
+Package: zlib1g
+Version: 1.2
+Section: libs
+
+Package: libc6
+Version: 2.36
+Section: libs
+
+Package: tar
+Version: 1.34
+Section: utils
+`
+	config := loadTestConfig(t, index, "app")
+	graph := buildTestGraph(t, index, "app")
+	var out bytes.Buffer
+	printBySection(&out, graph, config)
+
+	want := "\n=== libs (2) ===\n  libc6 [2.36]\n  zlib1g [1.2]\n" +
+		"\n=== utils (2) ===\n  app [1]\n  tar [1.34]\n" +
+		"\n=== (без секции) (1) ===\n  ghost [unknown]\n"
+	if out.String() != want {
+		t.Errorf("получено:\n%s\nожидалось:\n%s", out.String(), want)
+	}
+}
+
+// TestVersionFallbackWarning: предупреждение о выборе другой версии идёт в stderr
+// и отключается warn_on_version_fallback=false
+func TestVersionFallbackWarning(t *testing.T) {
+	const warning = "Внимание: version_fallback package=A requested=2.0 selected=1.0\n"
+	for _, tt := range []struct {
+		name     string
+		extra    []string
+		wantWarn bool
+	}{
+		{"по умолчанию", nil, true},
+		{"отключено", []string{"warn_on_version_fallback,false"}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "Packages", "Package: A\nVersion: 1.0\n")
+			lines := []string{"package_name,A", "repository_url,Packages", "test_mode,true", "version,2.0", "max_depth,5"}
+			writeTestConfig(t, dir, append(lines, tt.extra...)...)
+
+			stdout, stderr, code := runAnalyzer(t, dir, "config.csv")
+			if code != ExitSuccess {
+				t.Fatalf("код выхода %d, stderr:\n%s", code, stderr)
+			}
+			if strings.Contains(stdout, "version_fallback") {
+				t.Errorf("предупреждение попало в stdout:\n%s", stdout)
+			}
+			if got := strings.Contains(stderr, warning); got != tt.wantWarn {
+				t.Errorf("предупреждение в stderr: %v, ожидалось %v\n%s", got, tt.wantWarn, stderr)
+			}
+		})
+	}
+}
+
+// TestStatsJSON: stats-json выводит счётчики графа под стабильными именами полей
+func TestStatsJSON(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "Packages", "Package: A\nVersion: 1\nDepends: B, C\n\nPackage: B\nVersion: 1\nDepends: A\n\n"+
+		"Package: C\nVersion: 1\nDepends: ghost\n")
+	writeTestConfig(t, dir, "package_name,A", "repository_url,Packages", "test_mode,true", "version,", "max_depth,5",
+		"output_format,stats-json")
+
+	stdout, stderr, code := runAnalyzer(t, dir, "config.csv")
+	if code != ExitSuccess {
+		t.Fatalf("код выхода %d, stderr:\n%s", code, stderr)
+	}
+	var stats map[string]any
+	if err := json.Unmarshal([]byte(stdout), &stats); err != nil {
+		t.Fatalf("stdout не является JSON: %v\n%s", err, stdout)
+	}
+	for field, want := range map[string]any{
+		"root": "A", "version": "1", "nodes": 4.0, "edges": 4.0, "cycles": 1.0, "missing": 1.0, "depth": 2.0,
+		"edge_kinds": map[string]any{"Depends": 4.0},
+	} {
+		if got := stats[field]; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s = %v, ожидалось %v", field, got, want)
+		}
+	}
+	if _, ok := stats["diameter"]; ok {
+		t.Error("diameter выводится только при compute_diameter=true")
+	}
+}
+
+// TestSnapshotDate: snapshot_date переписывает repository_url на адрес снимка с меткой времени
+func TestSnapshotDate(t *testing.T) {
+	const repo = "http://deb.debian.org/debian/dists/bookworm/main/binary-amd64/Packages.gz"
+	for _, tt := range []struct {
+		name    string
+		extra   []string
+		wantURL string
+		wantErr string
+	}{
+		{"дата", []string{"snapshot_date,2023-06-10"},
+			"https://snapshot.debian.org/archive/debian/20230610T000000Z/dists/bookworm/main/binary-amd64/Packages.gz", ""},
+		{"метка времени и зеркало", []string{"snapshot_date,20230610T123000Z", "snapshot_mirror,http://snap.local/archive/debian/"},
+			"http://snap.local/archive/debian/20230610T123000Z/dists/bookworm/main/binary-amd64/Packages.gz", ""},
+		{"неверная дата", []string{"snapshot_date,10.06.2023"}, "", "неверное значение snapshot_date"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := []string{"package_name,A", "repository_url," + repo, "test_mode,false", "version,", "max_depth,5"}
+			filename := writeTestConfig(t, t.TempDir(), append(lines, tt.extra...)...)
+
+			var config *Config
+			var err error
+			captureOutput(t, func() { config, err = LoadConfig(filename) })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ожидалась ошибка %q, получено %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if config.RepositoryURL != tt.wantURL {
+				t.Errorf("repository_url = %s, ожидалось %s", config.RepositoryURL, tt.wantURL)
+			}
+		})
+	}
+}
+
+// TestExpandAlternatives: при expand_alternatives все три альтернативы становятся
+// узлами, а неосновные помечаются как рёбра Alternative; max_nodes соблюдается
+func TestExpandAlternatives(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: mta-a | mta-b | mta-c\n\n" +
+		"Package: mta-a\nVersion: 1\n\nPackage: mta-b\nVersion: 1\n\nPackage: mta-c\nVersion: 1\nDepends: libc\n\n" +
+		"Package: libc\nVersion: 1\n"
+
+	graph := buildTestGraph(t, index, "A")
+	if _, ok := graph.Nodes["mta-b"]; ok {
+		t.Error("без expand_alternatives альтернативы кроме первой не раскрываются")
+	}
+
+	graph = buildTestGraph(t, index, "A", "expand_alternatives,true")
+	for name, kind := range map[string]EdgeKind{"mta-a": KindDepends, "mta-b": KindAlternative, "mta-c": KindAlternative} {
+		if _, ok := graph.Nodes[name]; !ok {
+			t.Errorf("альтернатива %s должна стать узлом", name)
+		}
+		if got := graph.EdgeKind("A", name); got != kind {
+			t.Errorf("ребро A -> %s: %s, ожидалось %s", name, got, kind)
+		}
+	}
+	if _, ok := graph.Nodes["libc"]; !ok {
+		t.Error("зависимости альтернативы должны раскрываться")
+	}
+
+	graph = buildTestGraph(t, index, "A", "expand_alternatives,true", "max_nodes,3")
+	if len(graph.Nodes) > 3 || !graph.Truncated {
+		t.Errorf("max_nodes=3: узлов %d, truncated=%v", len(graph.Nodes), graph.Truncated)
+	}
+}
+
+// TestTreeJSONDiamond: в ромбе общий узел раскрывается один раз,
+// повторная встреча помечается repeated и не содержит детей
+func TestTreeJSONDiamond(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B, C\n\nPackage: B\nVersion: 1\nDepends: D\n\n" +
+		"Package: C\nVersion: 1\nDepends: D\n\nPackage: D\nVersion: 2\nDepends: E\n\nPackage: E\nVersion: 1\n"
+	config := loadTestConfig(t, index, "A")
+	graph := buildTestGraph(t, index, "A")
+
+	var out bytes.Buffer
+	if err := writeTreeJSON(&out, graph, config); err != nil {
+		t.Fatalf("writeTreeJSON: %v", err)
+	}
+	var tree treeNodeJSON
+	if err := json.Unmarshal(out.Bytes(), &tree); err != nil {
+		t.Fatalf("некорректный JSON: %v\n%s", err, out.String())
+	}
+
+	if tree.Name != "A" || len(tree.Children) != 2 {
+		t.Fatalf("корень: %+v", tree)
+	}
+	first := tree.Children[0].Children[0]
+	if first.Name != "D" || first.Repeated || len(first.Children) != 1 || first.Children[0].Name != "E" {
+		t.Errorf("первая встреча D должна раскрываться до E: %+v", first)
+	}
+	second := tree.Children[1].Children[0]
+	if second.Name != "D" || !second.Repeated || len(second.Children) != 0 || second.Version != "2" {
+		t.Errorf("повторная встреча D должна быть помечена repeated без детей: %+v", second)
+	}
+	if len(regexp.MustCompile(`"repeated":\s*true`).FindAllString(out.String(), -1)) != 1 {
+		t.Errorf("ожидался один повторный узел:\n%s", out.String())
+	}
+}
+
+// TestCommentLinesInIndex: строки "# ..." вне продолжений пропускаются и не ломают записи
+func TestCommentLinesInIndex(t *testing.T) {
+	const index = `# сгенерировано fixture-gen
+# набор: базовый
+
+Package: A
+# корневой пакет
+Version: 1
+Depends: B
+# конец записи A
+
+# отдельный комментарий между записями
+
+Package: B
+Version: 2
+Description: пакет B
+ # строка продолжения, не комментарий
+`
+	for _, lenient := range []bool{false, true} {
+		packages, malformed, err := parsePackagesFile(strings.NewReader(index), ParseOptions{Lenient: lenient})
+		if err != nil {
+			t.Fatalf("lenient=%v: parsePackagesFile: %v", lenient, err)
+		}
+		if len(malformed) != 0 {
+			t.Errorf("lenient=%v: комментарии не должны считаться повреждёнными записями: %v", lenient, malformed)
+		}
+		if len(packages) != 2 || packages[0].Name != "A" || packages[0].Version != "1" ||
+			!slices.Equal(packages[0].Dependencies, []string{"B"}) || packages[1].Name != "B" || packages[1].Version != "2" {
+			t.Errorf("lenient=%v: разобрано %+v", lenient, packages)
+		}
+	}
+}
+
+// TestVersionedProvides: ограничение на виртуальный пакет удовлетворяется только
+// версионированным Provides с подходящей версией
+func TestVersionedProvides(t *testing.T) {
+	const index = `Package: app
+Version: 1
+Depends: mail-transport (>= 2.0), libfoo-abi (>= 5)
+
+Package: plain-mta
+Version: 9
+Provides: mail-transport
+
+Package: old-mta
+Version: 4.0
+Provides: mail-transport (= 1.0)
+
+Package: new-mta
+Version: 4.1
+Provides: mail-transport (= 3.1), other
+
+Package: libfoo4
+Version: 4.0
+Provides: libfoo-abi (= 4)
+`
+	config := loadTestConfig(t, index, "app")
+	var graph *Graph
+	var err error
+	stdout, _ := captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+
+	if node := graph.Nodes["mail-transport"]; node == nil || node.ProvidedBy != "new-mta" || node.Version != "4.1" {
+		t.Errorf("mail-transport (>= 2.0) должен разрешаться через new-mta, получено %+v", node)
+	}
+	if want := "разрешена пакетом new-mta [4.1] (Provides mail-transport = 3.1)"; !strings.Contains(stdout, want) {
+		t.Errorf("нет сообщения %q:\n%s", want, stdout)
+	}
+	if node := graph.Nodes["libfoo-abi"]; node != nil && node.ProvidedBy == "libfoo4" {
+		t.Error("libfoo-abi (= 4) не удовлетворяет ограничению >= 5")
+	}
+	if !strings.Contains(stdout, "ни один пакет не предоставляет libfoo-abi (>= 5)") {
+		t.Errorf("нет предупреждения о неудовлетворённом ограничении:\n%s", stdout)
+	}
+}
+
+// TestRootDependencyCountWarning: ноль прямых зависимостей или больше root_deps_warn
+// дают предупреждение в stderr
+func TestRootDependencyCountWarning(t *testing.T) {
+	const index = "Package: lonely\nVersion: 1\n\nPackage: big\nVersion: 1\nDepends: a, b, c\n\n" +
+		"Package: a\nVersion: 1\n\nPackage: b\nVersion: 1\n\nPackage: c\nVersion: 1\n"
+	for _, tt := range []struct {
+		name  string
+		root  string
+		extra []string
+		want  string
+	}{
+		{"нет зависимостей", "lonely", nil, "Внимание: у пакета lonely нет прямых зависимостей"},
+		{"слишком много", "big", []string{"root_deps_warn,2"}, "Внимание: у пакета big слишком много прямых зависимостей: 3 (root_deps_warn=2)"},
+		{"в пределах порога", "big", []string{"root_deps_warn,3"}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			config := loadTestConfig(t, index, tt.root, tt.extra...)
+			var err error
+			stdout, stderr := captureOutput(t, func() { _, err = buildDependencyGraph(config) })
+			if err != nil {
+				t.Fatalf("buildDependencyGraph: %v", err)
+			}
+			if strings.Contains(stdout, "прямых зависимостей") {
+				t.Errorf("предупреждение попало в stdout:\n%s", stdout)
+			}
+			if tt.want == "" {
+				if strings.Contains(stderr, "прямых зависимостей") {
+					t.Errorf("лишнее предупреждение:\n%s", stderr)
+				}
+			} else if !strings.Contains(stderr, tt.want) {
+				t.Errorf("в stderr нет %q:\n%s", tt.want, stderr)
+			}
+		})
+	}
+}
+
+// TestAptRdependsGolden: вывод повторяет формат apt-rdepends (корень первым,
+// остальные пакеты по алфавиту, ограничения версий в скобках)
+func TestAptRdependsGolden(t *testing.T) {
+	const index = `Package: zsh
+Version: 5.9-4
+Pre-Depends: libc6
+Depends: zsh-common (= 5.9-4), libcap2
+
+Package: zsh-common
+Version: 5.9-4
+Recommends: libgdbm6
+
+Package: libcap2
+Version: 1:2.66-4
+Depends: libc6 (>= 2.34)
+
+Package: libc6
+Version: 2.36-9
+Depends: libgcc-s1
+
+Package: libgcc-s1
+Version: 12.2.0-14
+
+Package: libgdbm6
+Version: 1.23-3
+`
+	graph := buildTestGraph(t, index, "zsh", `dependency_kinds,"pre-depends,depends,recommends"`)
+	var out bytes.Buffer
+	printAptRdepends(&out, graph)
+
+	const golden = `zsh
+  PreDepends: libc6
+  Depends: zsh-common (= 5.9-4)
+  Depends: libcap2
+libc6
+  Depends: libgcc-s1
+libcap2
+  Depends: libc6 (>= 2.34)
+libgcc-s1
+libgdbm6
+zsh-common
+  Recommends: libgdbm6
+`
+	if out.String() != golden {
+		t.Errorf("получено:\n%s\nожидалось:\n%s", out.String(), golden)
+	}
+}
+
+// TestDirectoryMergeOrder: файлы каталога-источника объединяются по имени
+// независимо от порядка создания; скрытые файлы и подкаталоги пропускаются
+func TestDirectoryMergeOrder(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "lists")
+	if err := os.MkdirAll(filepath.Join(dir, "nested"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []struct{ name, index string }{
+		{"30-backports", "Package: C\nVersion: 3\n"},
+		{"10-main", "Package: A\nVersion: 1\n\nPackage: B\nVersion: 1\n"},
+		{".20-hidden", "Package: hidden\nVersion: 1\n"},
+		{"20-updates", "Package: B\nVersion: 2\n"},
+		{filepath.Join("nested", "Packages"), "Package: nested\nVersion: 1\n"},
+	} {
+		writeTestFile(t, dir, file.name, file.index)
+	}
+
+	var orders []string
+	for range 3 {
+		config := loadTestConfig(t, "", "A", "repository_url,"+dir)
+		var packages []Package
+		var err error
+		captureOutput(t, func() { packages, err = loadPackages(config) })
+		if err != nil {
+			t.Fatalf("loadPackages: %v", err)
+		}
+		var order []string
+		for _, pkg := range packages {
+			order = append(order, fmt.Sprintf("%s=%s@%s#%d", pkg.Name, pkg.Version, filepath.Base(pkg.Source), pkg.SourceIndex))
+		}
+		orders = append(orders, strings.Join(order, " "))
+	}
+
+	want := "A=1@10-main#0 B=1@10-main#0 B=2@20-updates#1 C=3@30-backports#2"
+	for _, order := range orders {
+		if order != want {
+			t.Errorf("порядок объединения %s, ожидалось %s", order, want)
+		}
+	}
+}
+
+// TestDiameter: диаметр — наибольшее кратчайшее расстояние между узлами,
+// он может не проходить через корень; средняя глубина считается всегда
+func TestDiameter(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B, C\n\nPackage: B\nVersion: 1\nDepends: D\n\n" +
+		"Package: C\nVersion: 1\nDepends: E\n\nPackage: D\nVersion: 1\nDepends: E\n\nPackage: E\nVersion: 1\nDepends: B\n"
+	// Кратчайший путь C -> E -> B -> D длиннее любого пути от корня;
+	// BFS даёт минимальные глубины A=0, B=C=1, D=E=2
+	extra := []string{"compute_diameter,true", "traversal,bfs"}
+	graph := buildTestGraph(t, index, "A", extra...)
+	if got := graph.Diameter(); got != 3 {
+		t.Errorf("Diameter() = %d, ожидалось 3", got)
+	}
+
+	stats := graph.Stats(loadTestConfig(t, index, "A", extra...))
+	if stats.Diameter == nil || *stats.Diameter != 3 {
+		t.Errorf("Stats().Diameter = %v, ожидалось 3", stats.Diameter)
+	}
+	if stats.AverageDepth != 1.2 {
+		t.Errorf("Stats().AverageDepth = %v, ожидалось 1.2", stats.AverageDepth)
+	}
+	if stats := graph.Stats(loadTestConfig(t, index, "A")); stats.Diameter != nil {
+		t.Error("без compute_diameter диаметр не вычисляется")
+	}
+}
+
+// TestBFSSpanningTree: при BFS общий узел ромба печатается только под родителем,
+// обнаружившим его на минимальной глубине, даже если более глубокий путь идёт первым
+func TestBFSSpanningTree(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B, C\n\nPackage: B\nVersion: 1\nDepends: X\n\n" +
+		"Package: X\nVersion: 1\nDepends: S\n\nPackage: C\nVersion: 1\nDepends: S\n\n" +
+		"Package: S\nVersion: 1\nDepends: T\n\nPackage: T\nVersion: 1\n"
+	extra := []string{"traversal,bfs"}
+	graph := buildTestGraph(t, index, "A", extra...)
+	if parent := graph.Nodes["S"].Parent; parent != "C" {
+		t.Errorf("S обнаружен из %q, ожидался C", parent)
+	}
+
+	var out bytes.Buffer
+	printGraph(&out, graph, loadTestConfig(t, index, "A", extra...))
+	want := `
+=== Граф зависимостей ===
+- A [1] (depth: 0)
+  - B [1] (depth: 1) [прямая]
+    - X [1] (depth: 2)
+  - C [1] (depth: 1) [прямая]
+    - S [1] (depth: 2)
+      - T [1] (depth: 3)
+`
+	if out.String() != want {
+		t.Errorf("получено:\n%s\nожидалось:\n%s", out.String(), want)
+	}
+}
+
+// writeTestDeb создаёт минимальный пакет .deb: ar-архив из debian-binary,
+// control.tar.gz с файлом control и пустого data.tar.gz
+func writeTestDeb(t *testing.T, dir, name, control string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString("!<arch>\n")
+	for _, member := range []string{"debian-binary", "control.tar.gz", "data.tar.gz"} {
+		data := []byte("2.0\n")
+		if member != "debian-binary" {
+			files := map[string]string{}
+			if member == "control.tar.gz" {
+				files["./control"] = control
+			}
+			var err error
+			if data, err = os.ReadFile(writeTestTarball(t, t.TempDir(), member, files)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		fmt.Fprintf(&buf, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", member, 0, 0, 0, "100644", len(data))
+		buf.Write(data)
+		if len(data)%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+	return writeTestFile(t, dir, name, buf.String())
+}
+
+// TestDebControlRoot: при index_type=deb корень берётся из control-файла пакета .deb,
+// а его зависимости разрешаются по индексу
+func TestDebControlRoot(t *testing.T) {
+	const index = "Package: mytool\nVersion: 0.9\nDepends: libold\n\nPackage: libfoo\nVersion: 2.1\nDepends: libc6\n\n" +
+		"Package: libc6\nVersion: 2.36\n\nPackage: libold\nVersion: 1\n"
+	deb := writeTestDeb(t, t.TempDir(), "mytool_1.0_amd64.deb",
+		"Package: mytool\nVersion: 1.0\nArchitecture: amd64\nDepends: libfoo (>= 2), libc6\nDescription: локальная сборка\n")
+
+	config := loadTestConfig(t, index, "mytool", "index_type,deb", "deb_file,"+deb)
+	var graph *Graph
+	var err error
+	stdout, _ := captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+	if !strings.Contains(stdout, "Пакет из "+deb+": mytool 1.0") {
+		t.Errorf("нет сообщения о пакете из .deb:\n%s", stdout)
+	}
+	if root := graph.Nodes["mytool"]; root == nil || root.Version != "1.0" {
+		t.Errorf("корень должен взяться из .deb, получено %+v", root)
+	}
+	if got := slices.Sorted(maps.Keys(graph.Nodes)); !slices.Equal(got, []string{"libc6", "libfoo", "mytool"}) {
+		t.Errorf("узлы %v: зависимости .deb должны разрешаться по индексу, версия из индекса не используется", got)
+	}
+
+	if _, err := parseDebControl([]byte("not a deb"), "broken.deb"); err == nil || exitCodeFor(err) != ExitParseError {
+		t.Errorf("для не-ar файла ожидалась ошибка разбора, получено %v", err)
+	}
+}
+
+// TestRequestsPerSecond: запросы из параллельных загрузок разносятся не чаще
+// requests_per_second в секунду общим ограничителем
+func TestRequestsPerSecond(t *testing.T) {
+	const requests = 4
+	arrivals := make(chan time.Time, requests)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrivals <- time.Now()
+		io.WriteString(w, "Package: A\nVersion: 1\n")
+	}))
+	defer server.Close()
+
+	filename := writeTestConfig(t, t.TempDir(), "package_name,A", "repository_url,"+server.URL+"/Packages",
+		"test_mode,false", "version,", "max_depth,5", "requests_per_second,20")
+	config, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	errs := make(chan error, requests)
+	for range requests {
+		// Копии конфигурации (как у параллельных источников) делят один ограничитель
+		sourceConfig := *config
+		go func() {
+			reader, err := fetchPackagesFile(sourceConfig.RepositoryURL, &sourceConfig)
+			if err == nil {
+				reader.(io.Closer).Close()
+			}
+			errs <- err
+		}()
+	}
+	for range requests {
+		if err := <-errs; err != nil {
+			t.Fatalf("fetchPackagesFile: %v", err)
+		}
+	}
+
+	times := make([]time.Time, 0, requests)
+	for range requests {
+		times = append(times, <-arrivals)
+	}
+	slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+	// Интервал 50 мс; небольшой допуск на планирование горутин
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("запросы %d и %d разделены %v, ожидалось не меньше 50ms", i-1, i, gap)
+		}
+	}
+}
+
+// TestHTMLEmbeddedData: страница содержит данные дерева в JSON, а также
+// списки узлов циклов и отсутствующих пакетов для подсветки
+func TestHTMLEmbeddedData(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B, ghost\n\nPackage: B\nVersion: 2\nDepends: C\n\n" +
+		"Package: C\nVersion: 3\nDepends: B\n"
+	config := loadTestConfig(t, index, "A")
+	graph := buildTestGraph(t, index, "A")
+
+	var out bytes.Buffer
+	if err := writeHTML(&out, graph, config); err != nil {
+		t.Fatalf("writeHTML: %v", err)
+	}
+	page := out.String()
+	if !strings.HasPrefix(page, "<!DOCTYPE html>") || !strings.Contains(page, "<title>Зависимости пакета A</title>") {
+		t.Errorf("неожиданный заголовок страницы:\n%.200s", page)
+	}
+
+	_, rest, found := strings.Cut(page, `<script id="graph-data" type="application/json">`)
+	embedded, _, closed := strings.Cut(rest, "</script>")
+	if !found || !closed {
+		t.Fatalf("на странице нет встроенных данных:\n%s", page)
+	}
+	var data htmlPageData
+	if err := json.Unmarshal([]byte(embedded), &data); err != nil {
+		t.Fatalf("встроенные данные не являются JSON: %v\n%s", err, embedded)
+	}
+
+	if data.Tree == nil || data.Tree.Name != "A" || len(data.Tree.Children) != 2 {
+		t.Fatalf("дерево: %+v", data.Tree)
+	}
+	if b := data.Tree.Children[0]; b.Name != "B" || b.Version != "2" || len(b.Children) != 1 || b.Children[0].Name != "C" {
+		t.Errorf("поддерево B: %+v", b)
+	}
+	if !slices.Equal(data.Cycle, []string{"B", "C"}) {
+		t.Errorf("узлы циклов %v, ожидалось [B C]", data.Cycle)
+	}
+	if !slices.Equal(data.Missing, []string{"ghost"}) {
+		t.Errorf("отсутствующие пакеты %v, ожидалось [ghost]", data.Missing)
+	}
+}
+
+// TestPartialGraphOnReadError: при обрыве загрузки посреди индекса и print_partial=true
+// возвращаются и ошибка, и граф по уже разобранным пакетам
+func TestPartialGraphOnReadError(t *testing.T) {
+	const received = "Package: A\nVersion: 1\nDepends: B, C\n\nPackage: B\nVersion: 1\nDepends: D\n\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Объявлен полный размер, но соединение рвётся после первых записей
+		w.Header().Set("Content-Length", "100000")
+		io.WriteString(w, received)
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	for _, printPartial := range []bool{false, true} {
+		filename := writeTestConfig(t, t.TempDir(), "package_name,A", "repository_url,"+server.URL+"/Packages",
+			"test_mode,false", "version,", "max_depth,5", "print_partial,"+strconv.FormatBool(printPartial))
+		config, err := LoadConfig(filename)
+		if err != nil {
+			t.Fatalf("LoadConfig: %v", err)
+		}
+
+		var graph *Graph
+		captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+		if err == nil {
+			t.Fatalf("print_partial=%v: ожидалась ошибка чтения", printPartial)
+		}
+		if !printPartial {
+			if graph != nil {
+				t.Error("без print_partial частичный граф не возвращается")
+			}
+			continue
+		}
+		if graph == nil || !graph.Partial {
+			t.Fatalf("ожидался частичный граф, получено %+v", graph)
+		}
+		if got := slices.Sorted(maps.Keys(graph.Nodes)); !slices.Equal(got, []string{"A", "B", "C", "D"}) {
+			t.Errorf("узлы частичного графа %v", got)
+		}
+		var out bytes.Buffer
+		printGraph(&out, graph, config)
+		if !strings.Contains(out.String(), "(частичный граф: загрузка индекса прервана ошибкой)") {
+			t.Errorf("вывод не помечен как частичный:\n%s", out.String())
+		}
+	}
+}
+
+// TestMultiArchResolution: пакет другой архитектуры удовлетворяет зависимость
+// только при Multi-Arch: foreign, а Multi-Arch: same требует совпадения архитектуры
+func TestMultiArchResolution(t *testing.T) {
+	const index = `Package: app
+Version: 1
+Architecture: amd64
+Depends: make-tool, libshared
+
+Package: make-tool
+Version: 4.3
+Architecture: arm64
+Multi-Arch: foreign
+
+Package: libshared
+Version: 2.0
+Architecture: arm64
+Multi-Arch: same
+`
+	var graph *Graph
+	var err error
+	config := loadTestConfig(t, index, "app")
+	stdout, _ := captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+	if node := graph.Nodes["make-tool"]; node == nil || node.Version != "4.3" {
+		t.Errorf("make-tool (Multi-Arch: foreign) должен разрешаться пакетом arm64, получено %+v", node)
+	}
+	if node := graph.Nodes["libshared"]; node == nil || node.Version != "unknown" {
+		t.Errorf("libshared (Multi-Arch: same) не должен разрешаться пакетом arm64, получено %+v", node)
+	}
+	if !strings.Contains(stdout, "нет пакета libshared для архитектуры amd64") {
+		t.Errorf("нет предупреждения о несовместимой архитектуре:\n%s", stdout)
+	}
+
+	candidates := []Package{
+		{Name: "p", Architecture: "arm64", MultiArch: "foreign"},
+		{Name: "p", Architecture: "arm64", MultiArch: "same"},
+		{Name: "p", Architecture: "all"},
+		{Name: "p", Architecture: "amd64", MultiArch: "same"},
+	}
+	var got []string
+	for _, pkg := range archCompatible(candidates, "amd64") {
+		got = append(got, pkg.Architecture+"/"+pkg.MultiArch)
+	}
+	if want := []string{"all/", "amd64/same", "arm64/foreign"}; !slices.Equal(got, want) {
+		t.Errorf("archCompatible = %v, ожидалось %v", got, want)
+	}
+}
+
+// TestMaxLineWidth: длинная строка узла обрезается до max_line_width с многоточием,
+// отступ и маркер "- " сохраняются
+func TestMaxLineWidth(t *testing.T) {
+	const long = "libextraordinarily-long-package-name-for-testing"
+	index := "Package: A\nVersion: 1\nDepends: B\n\nPackage: B\nVersion: 1\nDepends: " + long + "\n\n" +
+		"Package: " + long + "\nVersion: 1.0\n"
+	extra := []string{"max_line_width,30"}
+	graph := buildTestGraph(t, index, "A", extra...)
+
+	var out bytes.Buffer
+	printGraph(&out, graph, loadTestConfig(t, index, "A", extra...))
+	lines := strings.Split(strings.TrimPrefix(out.String(), "\n=== Граф зависимостей ===\n"), "\n")
+	want := []string{
+		"- A [1] (depth: 0)",
+		"  - B [1] (depth: 1) [прямая]",
+		"    - libextraordinarily-long…",
+		"",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("получено %q, ожидалось %q", lines, want)
+	}
+	for _, line := range lines {
+		if width := len([]rune(line)); width > 30 {
+			t.Errorf("строка %q шире 30 символов (%d)", line, width)
+		}
+	}
+}
+
+// TestCycleModeCounts: simple сообщает цикл B -> D -> B один раз, а all —
+// каждый путь от корня, заканчивающийся обратным ребром
+func TestCycleModeCounts(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B, C\n\nPackage: B\nVersion: 1\nDepends: D\n\n" +
+		"Package: C\nVersion: 1\nDepends: D\n\nPackage: D\nVersion: 1\nDepends: B\n"
+	for _, tt := range []struct {
+		mode string
+		want []string
+	}{
+		{"simple", []string{"B -> D -> B"}},
+		{"all", []string{"A -> B -> D -> B", "A -> C -> D -> B -> D"}},
+	} {
+		for _, traversal := range []string{"dfs", "bfs"} {
+			graph := buildTestGraph(t, index, "A", "cycle_mode,"+tt.mode, "traversal,"+traversal)
+			if !slices.Equal(graph.Cycles, tt.want) {
+				t.Errorf("cycle_mode=%s, traversal=%s: циклы %q, ожидалось %q", tt.mode, traversal, graph.Cycles, tt.want)
+			}
+		}
+	}
+}
+
+// TestInstalledSizeTotal: размеры узлов замыкания суммируются, общий узел ромба
+// учитывается один раз, пакеты без Installed-Size перечисляются отдельно
+func TestInstalledSizeTotal(t *testing.T) {
+	const index = `Package: A
+Version: 1
+Installed-Size: 1024
+Depends: B, C, ghost
+
+Package: B
+Version: 1
+Installed-Size: 2048
+Depends: D
+
+Package: C
+Version: 1
+Installed-Size: 512
+Depends: D
+
+Package: D
+Version: 1
+Installed-Size: 1536
+`
+	graph := buildTestGraph(t, index, "A")
+	var out bytes.Buffer
+	printInstalledSizes(&out, graph, loadTestConfig(t, index, "A"))
+
+	want := "  A [1] — 1024 KB\n  B [1] — 2048 KB\n  C [1] — 512 KB\n  D [1] — 1536 KB\n  ghost [unknown] — размер неизвестен\n" +
+		"\nСуммарный размер установки: 5.0 MB\nПакетов без Installed-Size: 1\n"
+	if out.String() != want {
+		t.Errorf("получено:\n%s\nожидалось:\n%s", out.String(), want)
+	}
+}
+
+// TestMinPrintDepth: при min_print_depth=3 узлы уровней 0-2 не выводятся,
+// а отступ глубоких узлов отсчитывается от третьего уровня
+func TestMinPrintDepth(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B, X\n\nPackage: B\nVersion: 1\nDepends: C\n\n" +
+		"Package: C\nVersion: 1\nDepends: D\n\nPackage: D\nVersion: 1\nDepends: E\n\nPackage: E\nVersion: 1\n\n" +
+		"Package: X\nVersion: 1\n"
+	extra := []string{"min_print_depth,3"}
+	graph := buildTestGraph(t, index, "A", extra...)
+
+	var out bytes.Buffer
+	printGraph(&out, graph, loadTestConfig(t, index, "A", extra...))
+	want := "\n=== Граф зависимостей ===\n- D [1] (depth: 3)\n  - E [1] (depth: 4)\n"
+	if out.String() != want {
+		t.Errorf("получено:\n%s\nожидалось:\n%s", out.String(), want)
+	}
+}
+
+// TestGzipOutputFile: output_file с расширением .gz сжимается, а распакованное
+// содержимое совпадает с выводом в обычный файл
+func TestGzipOutputFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "Packages", "Package: A\nVersion: 1\nDepends: B\n\nPackage: B\nVersion: 2\n")
+	outputs := map[string][]byte{}
+	for _, name := range []string{"graph.jsonl", "graph.jsonl.gz"} {
+		writeTestConfig(t, dir, "package_name,A", "repository_url,Packages", "test_mode,true", "version,", "max_depth,5",
+			"output_format,jsonl", "output_file,"+name)
+		if _, stderr, code := runAnalyzer(t, dir, "config.csv"); code != ExitSuccess {
+			t.Fatalf("%s: код выхода %d, stderr:\n%s", name, code, stderr)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs[name] = data
+	}
+
+	plain, compressed := outputs["graph.jsonl"], outputs["graph.jsonl.gz"]
+	if bytes.HasPrefix(plain, []byte{0x1f, 0x8b}) {
+		t.Error("файл без .gz не должен сжиматься")
+	}
+	gzReader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("файл .gz не является gzip: %v", err)
+	}
+	unpacked, err := io.ReadAll(gzReader)
+	if err != nil {
+		t.Fatalf("ошибка распаковки: %v", err)
+	}
+	if len(plain) == 0 || !bytes.Equal(unpacked, plain) {
+		t.Errorf("распакованный вывод:\n%s\nотличается от обычного:\n%s", unpacked, plain)
+	}
+}
+
+// TestQuietOnSuccess: при quiet_on_success успешный запуск выводит только результат,
+// а при ошибке накопленная диагностика выводится в stderr
+func TestQuietOnSuccess(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "Packages", "Package: A\nVersion: 1\nDepends: B\n\nPackage: B\nVersion: 1\n")
+	writeConfig := func(root string) {
+		writeTestConfig(t, dir, "package_name,"+root, "repository_url,Packages", "test_mode,true", "version,", "max_depth,5",
+			"output_format,summary", "quiet_on_success,true")
+	}
+
+	writeConfig("A")
+	stdout, stderr, code := runAnalyzer(t, dir, "config.csv")
+	if code != ExitSuccess {
+		t.Fatalf("код выхода %d", code)
+	}
+	if want := "root=A version=1 nodes=2 edges=1 cycles=0 missing=0 depth=1\n"; stdout != want {
+		t.Errorf("stdout = %q, ожидался только результат %q", stdout, want)
+	}
+	if stderr != "" {
+		t.Errorf("при успехе диагностика не выводится, stderr:\n%s", stderr)
+	}
+
+	writeConfig("Z")
+	stdout, stderr, code = runAnalyzer(t, dir, "config.csv")
+	if code != ExitNotFound {
+		t.Fatalf("код выхода %d, ожидался %d", code, ExitNotFound)
+	}
+	if stdout != "" {
+		t.Errorf("при ошибке диагностика идёт в stderr, stdout:\n%s", stdout)
+	}
+	for _, want := range []string{"Загрузка данных из: Packages", "Найдено пакетов: 2", "пакет Z не найден"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("в stderr нет %q:\n%s", want, stderr)
+		}
+	}
+}
+
+// TestShowEdgeVersions: рёбра дерева аннотируются ограничением и выбранной версией
+// с учётом ограничений и закреплений
+func TestShowEdgeVersions(t *testing.T) {
+	const index = `Package: A
+Version: 1
+Depends: libx (>= 1.0), liby, ghost (>= 2)
+
+Package: libx
+Version: 1.2.3
+
+Package: libx
+Version: 0.9
+
+Package: liby
+Version: 2.0
+
+Package: liby
+Version: 1.0
+`
+	extra := []string{"show_edge_versions,true", "pins,liby=1.0"}
+	graph := buildTestGraph(t, index, "A", extra...)
+
+	var out bytes.Buffer
+	printGraph(&out, graph, loadTestConfig(t, index, "A", extra...))
+	want := `
+=== Граф зависимостей ===
+- A [1] (depth: 0)
+  - libx (>= 1.0 -> выбрана 1.2.3) [1.2.3] (depth: 1) [прямая]
+  - liby (любая -> выбрана 1.0) [1.0] (depth: 1) [прямая]
+  - ghost (>= 2 -> не найдена) [unknown] (depth: 1) [прямая]
+`
+	if out.String() != want {
+		t.Errorf("получено:\n%s\nожидалось:\n%s", out.String(), want)
+	}
+}
+
+// TestBatchMode: пакетный режим пишет по файлу на каждый корень из списка,
+// ошибка одного корня не прерывает обработку остальных
+func TestBatchMode(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "Packages", "Package: A\nVersion: 1\nDepends: C\n\nPackage: B\nVersion: 2\nDepends: C\n\n"+
+		"Package: C\nVersion: 3\n")
+	writeTestFile(t, dir, "roots.txt", "# корни отчёта\nA\nmissing\nB\nC\n")
+	writeTestConfig(t, dir, "package_name,A", "repository_url,Packages", "test_mode,true", "version,", "max_depth,5",
+		"output_format,stats-json")
+
+	stdout, stderr, code := runAnalyzer(t, dir, "batch", "roots.txt", "reports", "config.csv")
+	if code == ExitSuccess {
+		t.Error("ошибка корня missing должна давать ненулевой код выхода")
+	}
+	if !strings.Contains(stderr, "missing: пакет missing не найден") {
+		t.Errorf("в stderr нет ошибки корня missing:\n%s", stderr)
+	}
+	if !strings.Contains(stdout, "Обработано корней: 4, с ошибками: 1") {
+		t.Errorf("нет итога пакетного режима:\n%s", stdout)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "reports"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	if want := []string{"A.json", "B.json", "C.json"}; !slices.Equal(files, want) {
+		t.Fatalf("файлы результатов %v, ожидалось %v", files, want)
+	}
+	for root, nodes := range map[string]float64{"A": 2, "B": 2, "C": 1} {
+		var stats map[string]any
+		data, err := os.ReadFile(filepath.Join(dir, "reports", root+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &stats); err != nil {
+			t.Fatalf("%s.json: %v", root, err)
+		}
+		if stats["root"] != root || stats["nodes"] != nodes {
+			t.Errorf("%s.json: root=%v nodes=%v", root, stats["root"], stats["nodes"])
+		}
+	}
+}
+
+// TestIncludeEssential: обязательные пакеты добавляются как прямые зависимости корня,
+// уже объявленные не дублируются, о добавленных сообщается
+func TestIncludeEssential(t *testing.T) {
+	const index = `Package: app
+Version: 1
+Depends: libc6
+
+Package: libc6
+Version: 2.36
+Essential: yes
+
+Package: dpkg
+Version: 1.21
+Essential: yes
+Depends: tar
+
+Package: tar
+Version: 1.34
+
+Package: base-files
+Version: 12
+Essential: no
+`
+	if graph := buildTestGraph(t, index, "app"); len(graph.Nodes) != 2 {
+		t.Errorf("без include_essential обязательные пакеты не добавляются: %v", slices.Sorted(maps.Keys(graph.Nodes)))
+	}
+
+	config := loadTestConfig(t, index, "app", "include_essential,true")
+	var graph *Graph
+	var err error
+	stdout, _ := captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+	if got := graph.Nodes["app"].Dependencies; !slices.Equal(got, []string{"libc6", "dpkg"}) {
+		t.Errorf("зависимости корня %v, ожидалось [libc6 dpkg]", got)
+	}
+	if got := slices.Sorted(maps.Keys(graph.Nodes)); !slices.Equal(got, []string{"app", "dpkg", "libc6", "tar"}) {
+		t.Errorf("узлы %v: зависимости обязательных пакетов раскрываются, Essential: no не учитывается", got)
+	}
+	if node := graph.Nodes["dpkg"]; node == nil || !node.IsDirect {
+		t.Error("обязательный пакет должен быть прямой зависимостью корня")
+	}
+	if want := "Добавлены обязательные пакеты (Essential) как зависимости app: dpkg\n"; !strings.Contains(stdout, want) {
+		t.Errorf("нет сообщения %q:\n%s", want, stdout)
+	}
+}
+
+// TestDiamonds: точка схождения ромба сообщается вместе с двумя родителями
+func TestDiamonds(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B, C, E\n\nPackage: B\nVersion: 1\nDepends: D\n\n" +
+		"Package: C\nVersion: 1\nDepends: D\n\nPackage: D\nVersion: 1\n\nPackage: E\nVersion: 1\n"
+	graph := buildTestGraph(t, index, "A")
+
+	diamonds := graph.Diamonds()
+	if len(diamonds) != 1 || diamonds[0].Node != "D" || !slices.Equal(diamonds[0].Parents, []string{"B", "C"}) {
+		t.Errorf("Diamonds() = %+v, ожидалось D <- B, C", diamonds)
+	}
+	var out bytes.Buffer
+	printDiamonds(&out, graph)
+	if out.String() != "D <- B, C\n" {
+		t.Errorf("получено %q", out.String())
+	}
+
+	out.Reset()
+	printDiamonds(&out, buildTestGraph(t, "Package: A\nVersion: 1\nDepends: B\n\nPackage: B\nVersion: 1\n", "A"))
+	if out.String() != "ромбовидные зависимости не обнаружены\n" {
+		t.Errorf("для графа без ромбов получено %q", out.String())
+	}
+}
+
+// TestVerifyRootDeb: расхождения между зависимостями корня в индексе и в control-файле
+// его .deb (по полю Filename) выводятся как предупреждения
+func TestVerifyRootDeb(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "pool", "main", "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestDeb(t, filepath.Join(dir, "pool", "main", "a"), "app_1.0-2_amd64.deb",
+		"Package: app\nVersion: 1.0-2\nDepends: libc6, libssl3\n")
+	index := writeTestFile(t, dir, "Packages", "Package: app\nVersion: 1.0-1\nFilename: pool/main/a/app_1.0-2_amd64.deb\n"+
+		"Depends: libc6, libssl1.1\n\nPackage: libc6\nVersion: 2.36\n")
+
+	config := loadTestConfig(t, "", "app", "repository_url,"+index, "verify_root_deb,true")
+	var err error
+	stdout, _ := captureOutput(t, func() { _, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+	for _, want := range []string{
+		"Проверка control-файла " + filepath.Join(dir, "pool", "main", "a", "app_1.0-2_amd64.deb"),
+		"Внимание: версия в индексе 1.0-1, в .deb 1.0-2",
+		"Внимание: Depends: только в индексе: libssl1.1",
+		"Внимание: Depends: только в .deb: libssl3",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("нет строки %q:\n%s", want, stdout)
+		}
+	}
+}
+
+// TestASCIIOnly: имя с не-ASCII байтом (повреждённая кодировка) при ascii_only
+// отклоняется с сообщением в stderr, а без него молча обрезается
+func TestASCIIOnly(t *testing.T) {
+	const index = "Package: app\nVersion: 1\nDepends: libc6, lib\xe9foo (>= 1), zlib1g\n"
+
+	packages, _, err := parsePackagesFile(strings.NewReader(index), ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := packages[0].Dependencies; !slices.Equal(got, []string{"libc6", "lib", "zlib1g"}) {
+		t.Errorf("без ascii_only: %q", got)
+	}
+
+	_, stderr := captureOutput(t, func() {
+		packages, _, err = parsePackagesFile(strings.NewReader(index), ParseOptions{ASCIIOnly: true})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := packages[0].Dependencies; !slices.Equal(got, []string{"libc6", "zlib1g"}) {
+		t.Errorf("ascii_only: %q, ожидалось [libc6 zlib1g]", got)
+	}
+	if want := "Внимание: пакет app: некорректное имя \"lib\\xe9foo\" в поле Depends пропущено"; !strings.Contains(stderr, want) {
+		t.Errorf("в stderr нет %q:\n%s", want, stderr)
+	}
+}
+
+// TestOutputTemplate: шаблон node выполняется для каждого узла, header и footer — один раз
+// со статистикой графа; файл без определений целиком считается шаблоном узла
+func TestOutputTemplate(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B, C\n\nPackage: B\nVersion: 2\nDepends: C\n\nPackage: C\nVersion: 3\n"
+	dir := t.TempDir()
+	full := writeTestFile(t, dir, "report.tmpl", `{{define "header"}}# {{.Root}} {{.Version}}: {{.Nodes}} узла
+{{end}}{{define "node"}}{{.Name}}@{{.Version}} d={{.Depth}}{{if .Direct}} direct{{end}}{{range .Dependencies}} ->{{.}}{{end}}
+{{end}}{{define "footer"}}рёбер: {{.Edges}}
+{{end}}`)
+	simple := writeTestFile(t, dir, "simple.tmpl", "{{.Name}}={{.Version}};")
+
+	for _, tt := range []struct {
+		file string
+		want string
+	}{
+		{full, "# A 1: 3 узла\nA@1 d=0 ->B ->C\nB@2 d=1 direct ->C\nC@3 d=1 direct\nрёбер: 3\n"},
+		{simple, "A=1;B=2;C=3;"},
+	} {
+		config := loadTestConfig(t, index, "A", "template,"+tt.file, "traversal,bfs")
+		graph := buildTestGraph(t, index, "A", "traversal,bfs")
+		var out bytes.Buffer
+		if err := writeTemplate(&out, graph, config); err != nil {
+			t.Fatalf("%s: writeTemplate: %v", filepath.Base(tt.file), err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: получено:\n%s\nожидалось:\n%s", filepath.Base(tt.file), out.String(), tt.want)
+		}
+	}
+
+	broken := writeTestFile(t, dir, "broken.tmpl", "{{.Name")
+	filename := writeTestConfig(t, t.TempDir(), "package_name,A", "repository_url,Packages", "test_mode,true", "version,",
+		"max_depth,5", "template,"+broken)
+	if _, err := LoadConfig(filename); err == nil || !strings.Contains(err.Error(), "ошибка шаблона template") {
+		t.Errorf("некорректный шаблон должен давать ошибку конфигурации, получено %v", err)
+	}
+}
+
+// TestResolutionReport: для каждого найденного пакета выводится объявлено/разрешено
+// с процентом; виртуальные пакеты с Provides считаются разрешёнными
+func TestResolutionReport(t *testing.T) {
+	const index = `Package: app
+Version: 1
+Depends: libc6, mail-transport, ghost, phantom
+
+Package: libc6
+Version: 2.36
+Depends: libgcc-s1
+
+Package: exim4
+Version: 4.96
+Provides: mail-transport
+`
+	graph := buildTestGraph(t, index, "app")
+	var out bytes.Buffer
+	printResolutionReport(&out, graph, loadTestConfig(t, index, "app"))
+
+	want := "app [1]: 4/2 (50%) не разрешены: ghost, phantom\n" +
+		"libc6 [2.36]: 1/0 (0%) не разрешены: libgcc-s1\n" +
+		"mail-transport [4.96]: 0/0\n" +
+		"\nИтого объявлено/разрешено: 5/2 (40%)"
+	if !strings.HasPrefix(out.String(), want) {
+		t.Errorf("получено:\n%s\nожидалось:\n%s", out.String(), want)
+	}
+}
+
+// serveTestFTP запускает минимальный FTP-сервер (USER/PASS, TYPE, EPSV/PASV, RETR)
+// с файлами files; пользователь anonymous входит с любым паролем, alice — с паролем secret.
+// При epsv=false команда EPSV отклоняется, и клиент должен перейти на PASV;
+// при abort передача обрывается на половине файла с ответом 426
+func serveTestFTP(t *testing.T, files map[string][]byte, epsv, abort bool) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	session := func(conn net.Conn) {
+		control := textproto.NewConn(conn)
+		defer control.Close()
+		var user string
+		var passive net.Listener
+		defer func() {
+			if passive != nil {
+				passive.Close()
+			}
+		}()
+
+		control.PrintfLine("220 test ftp")
+		for {
+			line, err := control.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "USER":
+				user = arg
+				control.PrintfLine("331 password required")
+			case "PASS":
+				if user == "anonymous" || (user == "alice" && arg == "secret") {
+					control.PrintfLine("230 logged in")
+				} else {
+					control.PrintfLine("530 login incorrect")
+				}
+			case "TYPE":
+				control.PrintfLine("200 type set")
+			case "EPSV", "PASV":
+				if verb == "EPSV" && !epsv {
+					control.PrintfLine("500 EPSV not supported")
+					continue
+				}
+				if passive, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+					control.PrintfLine("425 cannot open data connection")
+					continue
+				}
+				port := passive.Addr().(*net.TCPAddr).Port
+				if verb == "EPSV" {
+					control.PrintfLine("229 Entering Extended Passive Mode (|||%d|)", port)
+				} else {
+					control.PrintfLine("227 Entering Passive Mode (127,0,0,1,%d,%d)", port/256, port%256)
+				}
+			case "RETR":
+				content, ok := files[arg]
+				if !ok || passive == nil {
+					control.PrintfLine("550 file not found")
+					continue
+				}
+				control.PrintfLine("150 opening data connection")
+				data, err := passive.Accept()
+				if err != nil {
+					return
+				}
+				if abort {
+					data.Write(content[:len(content)/2])
+					data.Close()
+					control.PrintfLine("426 connection closed; transfer aborted")
+					continue
+				}
+				data.Write(content)
+				data.Close()
+				control.PrintfLine("226 transfer complete")
+			case "QUIT":
+				control.PrintfLine("221 bye")
+				return
+			default:
+				control.PrintfLine("502 not implemented")
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go session(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// TestFTPRepository: индекс загружается по ftp:// через EPSV или PASV, с анонимным
+// входом или учётными данными из URL, .gz распаковывается как при HTTP
+func TestFTPRepository(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B\n\nPackage: B\nVersion: 2\n"
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	io.WriteString(gz, index)
+	gz.Close()
+	files := map[string][]byte{
+		"/debian/Packages":    []byte(index),
+		"/debian/Packages.gz": compressed.Bytes(),
+	}
+
+	for _, tt := range []struct {
+		name    string
+		epsv    bool
+		path    string
+		userURL string
+		wantErr string
+	}{
+		{"EPSV, анонимно", true, "/debian/Packages", "", ""},
+		{"PASV, gzip", false, "/debian/Packages.gz", "", ""},
+		{"учётные данные", true, "/debian/Packages", "alice:secret@", ""},
+		{"неверный пароль", true, "/debian/Packages", "alice:wrong@", "вход отклонён (код 530)"},
+		{"нет файла", true, "/debian/missing", "", "550"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			address := serveTestFTP(t, files, tt.epsv, false)
+			reader, err := fetchPackagesFile("ftp://"+tt.userURL+address+tt.path, &Config{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ожидалась ошибка %q, получено %v", tt.wantErr, err)
+				}
+				if code := exitCodeFor(err); code != ExitFetchError {
+					t.Errorf("код выхода %d, ожидался %d", code, ExitFetchError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchPackagesFile: %v", err)
+			}
+			packages, _, err := parsePackagesFile(reader, ParseOptions{})
+			if closer, ok := reader.(io.Closer); ok {
+				closer.Close()
+			}
+			if err != nil {
+				t.Fatalf("parsePackagesFile: %v", err)
+			}
+			if len(packages) != 2 || packages[0].Name != "A" || packages[1].Version != "2" {
+				t.Errorf("разобрано %+v", packages)
+			}
+		})
+	}
+}
+
+// TestFTPTransferAborted: обрыв передачи (ответ 426 после закрытия канала данных)
+// — ошибка чтения и закрытия, а не успешно прочитанная половина индекса
+func TestFTPTransferAborted(t *testing.T) {
+	index := []byte("Package: A\nVersion: 1\nDepends: B\n\nPackage: B\nVersion: 2\n")
+	address := serveTestFTP(t, map[string][]byte{"/debian/Packages": index}, true, true)
+
+	reader, err := fetchPackagesFile("ftp://"+address+"/debian/Packages", &Config{})
+	if err != nil {
+		t.Fatalf("fetchPackagesFile: %v", err)
+	}
+	data, readErr := io.ReadAll(reader)
+	if readErr == nil || !strings.Contains(readErr.Error(), "426") {
+		t.Errorf("ожидалась ошибка чтения с кодом 426, получено %v (прочитано %q)", readErr, data)
+	}
+	closeErr := reader.(io.Closer).Close()
+	if closeErr == nil || !strings.Contains(closeErr.Error(), "426") {
+		t.Errorf("ожидалась ошибка закрытия с кодом 426, получено %v", closeErr)
+	}
+}
+
+// TestStrictVersion: при strict_version=true отсутствие запрошенной версии корня —
+// ошибка со списком доступных версий, без него выбирается другая версия
+func TestStrictVersion(t *testing.T) {
+	const index = "Package: A\nVersion: 2.0\n\nPackage: A\nVersion: 1.5\n"
+	for _, strict := range []bool{false, true} {
+		config := loadTestConfig(t, index, "A", "version,3.0", "strict_version,"+strconv.FormatBool(strict))
+		var graph *Graph
+		var err error
+		captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+		if !strict {
+			if err != nil || graph.Nodes["A"].Version != "2.0" {
+				t.Errorf("без strict_version ожидался выбор 2.0, получено %v", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "версия 3.0 пакета A не найдена (доступны: 2.0, 1.5; strict_version=true)") {
+			t.Fatalf("ожидалась ошибка strict_version, получено %v", err)
+		}
+		if code := exitCodeFor(err); code != ExitNotFound {
+			t.Errorf("код выхода %d, ожидался %d", code, ExitNotFound)
+		}
+	}
+}
+
+// TestTsortPairs: каждое ребро выводится парой "от до", изолированный узел —
+// парой из самого себя, чтобы tsort его не потерял
+func TestTsortPairs(t *testing.T) {
+	graph := buildTestGraph(t, "Package: A\nVersion: 1\nDepends: B, C\n\nPackage: B\nVersion: 1\nDepends: C\n\n"+
+		"Package: C\nVersion: 1\n", "A")
+	var out bytes.Buffer
+	printTsortPairs(&out, graph)
+	if want := "A B\nA C\nB C\n"; out.String() != want {
+		t.Errorf("получено %q, ожидалось %q", out.String(), want)
+	}
+
+	out.Reset()
+	printTsortPairs(&out, buildTestGraph(t, "Package: A\nVersion: 1\n", "A"))
+	if want := "A A\n"; out.String() != want {
+		t.Errorf("для одиночного узла получено %q, ожидалось %q", out.String(), want)
+	}
+}
+
+// TestDepthShading: при depth_shading узлы разной глубины получают разный fillcolor
+// от светлого к тёмному, на тёмном фоне текст белый
+func TestDepthShading(t *testing.T) {
+	const index = "Package: A\nVersion: 1\nDepends: B\n\nPackage: B\nVersion: 1\nDepends: C\n\n" +
+		"Package: C\nVersion: 1\nDepends: D\n\nPackage: D\nVersion: 1\n"
+	graph := buildTestGraph(t, index, "A")
+
+	dot := generateGraphvizDOT(graph, loadTestConfig(t, index, "A", "depth_shading,true"))
+	if !strings.Contains(dot, "style=filled") {
+		t.Errorf("узлы должны заливаться цветом:\n%s", dot)
+	}
+	for _, want := range []string{
+		`"B" [label="B\n[1]", fillcolor="#e0ecff"];`,
+		`"C" [label="C\n[1]", fillcolor="#809dce"];`,
+		`"D" [label="D\n[1]", fillcolor="#1f4e9c", fontcolor="white"];`,
+		`"A" [label="A\n[1]\n(целевой пакет)", fillcolor="lightgreen"];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("в DOT нет %s:\n%s", want, dot)
+		}
+	}
+
+	plain := generateGraphvizDOT(graph, loadTestConfig(t, index, "A"))
+	if strings.Contains(plain, "#e0ecff") || strings.Contains(plain, "#1f4e9c") {
+		t.Errorf("без depth_shading градиент не применяется:\n%s", plain)
+	}
+}
+
+// TestMetapackageRoot: корень из раздела metapackages помечается как метапакет,
+// а expand_metapackage_only_depth=1 оставляет только его прямые зависимости
+func TestMetapackageRoot(t *testing.T) {
+	const index = "Package: desktop\nVersion: 1\nSection: metapackages\nDepends: editor, browser\n\n" +
+		"Package: editor\nVersion: 1\nDepends: libedit\n\n" +
+		"Package: browser\nVersion: 1\n\n" +
+		"Package: libedit\nVersion: 1\n"
+
+	full := buildTestGraph(t, index, "desktop")
+	if full.Metapackage != "Section: metapackages" {
+		t.Errorf("Metapackage = %q, ожидался признак раздела", full.Metapackage)
+	}
+	if _, ok := full.Nodes["libedit"]; !ok {
+		t.Errorf("без ограничения глубины libedit должен быть в графе: %v", slices.Sorted(maps.Keys(full.Nodes)))
+	}
+
+	config := loadTestConfig(t, index, "desktop", "expand_metapackage_only_depth,1")
+	var graph *Graph
+	var err error
+	stdout, _ := captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+	for _, want := range []string{"Пакет desktop — метапакет (Section: metapackages)", "Глубина ограничена до 1"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("в выводе нет %q:\n%s", want, stdout)
+		}
+	}
+	names := slices.Sorted(maps.Keys(graph.Nodes))
+	if !slices.Equal(names, []string{"browser", "desktop", "editor"}) {
+		t.Errorf("узлы = %v, ожидались только корень и прямые зависимости", names)
+	}
+
+	var buf bytes.Buffer
+	printGraph(&buf, graph, config)
+	if !strings.Contains(buf.String(), "(desktop — метапакет: Section: metapackages)") {
+		t.Errorf("дерево не помечает метапакет:\n%s", buf.String())
+	}
+	if dot := generateGraphvizDOT(graph, config); !strings.Contains(dot, "(целевой метапакет)") {
+		t.Errorf("DOT не помечает метапакет:\n%s", dot)
+	}
+
+	// Эвристика без раздела: много зависимостей и почти пустой пакет
+	pkg := &Package{Name: "task", Dependencies: []string{"a", "b", "c", "d", "e"}, InstalledSize: 10}
+	if reason := metapackageReason(pkg); reason == "" {
+		t.Error("пакет с 5 зависимостями и Installed-Size 10 КБ должен считаться метапакетом")
+	}
+	pkg.InstalledSize = 5000
+	if reason := metapackageReason(pkg); reason != "" {
+		t.Errorf("крупный пакет не метапакет, получено %q", reason)
+	}
+}
+
+// TestRootConflictInClosure: транзитивная зависимость, с которой конфликтует корень,
+// обнаруживается и сообщается вместе с путём до неё
+func TestRootConflictInClosure(t *testing.T) {
+	const index = "Package: app\nVersion: 1\nDepends: libfoo\nConflicts: oldtool\nBreaks: libbar (<< 2.0)\n\n" +
+		"Package: libfoo\nVersion: 1\nDepends: oldtool, libbar\n\n" +
+		"Package: oldtool\nVersion: 0.9\n\n" +
+		"Package: libbar\nVersion: 2.1\n"
+
+	config := loadTestConfig(t, index, "app")
+	var graph *Graph
+	var err error
+	stdout, _ := captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+
+	want := []Conflict{{Node: "app", Relation: "Conflicts", Target: "oldtool"}}
+	if got := graph.Conflicts(); !slices.Equal(got, want) {
+		t.Errorf("Conflicts() = %+v, ожидалось %+v (libbar 2.1 не подпадает под Breaks << 2.0)", got, want)
+	}
+	const warning = "противоречивое замыкание: app объявляет Conflicts: oldtool, но в графе есть oldtool [0.9] (app -> libfoo -> oldtool)"
+	if !strings.Contains(stdout, warning) {
+		t.Errorf("в выводе нет предупреждения %q:\n%s", warning, stdout)
+	}
+}
+
+// TestEventsBuildOrder: output_format=events выдаёт события построения в порядке
+// их возникновения и завершает поток итоговой записью done
+func TestEventsBuildOrder(t *testing.T) {
+	dir := t.TempDir()
+	repo := writeTestFile(t, dir, "Packages", "Package: A\nVersion: 1\nDepends: B\n\n"+
+		"Package: B\nVersion: 2\nDepends: C\n\nPackage: C\nVersion: 3\nDepends: A\n")
+	writeTestConfig(t, dir, "package_name,A", "repository_url,"+repo, "test_mode,true",
+		"version,", "max_depth,5", "output_format,events")
+	stdout, stderr, code := runAnalyzer(t, dir, "config.csv")
+	if code != ExitSuccess {
+		t.Fatalf("код завершения %d, stderr: %s", code, stderr)
+	}
+
+	want := []string{
+		`{"event":"packages_parsed","count":3}`,
+		`{"event":"node","name":"A","version":"1","depth":0}`,
+		`{"event":"node","name":"B","version":"2","depth":1}`,
+		`{"event":"node","name":"C","version":"3","depth":2}`,
+		`{"event":"cycle","path":"A -\u003e B -\u003e C -\u003e A"}`,
+		`{"event":"done","root":"A","nodes":3,"edges":3,"cycles":1}`,
+	}
+	if got := strings.Split(strings.TrimSpace(stdout), "\n"); !slices.Equal(got, want) {
+		t.Errorf("события:\n%s\nожидалось:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Цикл A -> B -> A замыкается до обхода C и D, но событие о нём приходит
+	// после всех узлов: циклы ищутся по готовому графу
+	for _, traversal := range []string{"dfs", "bfs"} {
+		config := loadTestConfig(t, "Package: A\nVersion: 1\nDepends: C, B\n\nPackage: B\nVersion: 1\nDepends: A\n\n"+
+			"Package: C\nVersion: 1\nDepends: D\n\nPackage: D\nVersion: 1\n", "A", "traversal,"+traversal)
+		var events []string
+		config.ProgressFunc = func(event ProgressEvent) {
+			switch e := event.(type) {
+			case NodeVisited:
+				events = append(events, "node "+e.Name)
+			case CycleFound:
+				events = append(events, "cycle "+e.Path)
+			}
+		}
+		captureOutput(t, func() {
+			if _, err := buildDependencyGraph(config); err != nil {
+				t.Errorf("buildDependencyGraph: %v", err)
+			}
+		})
+		if len(events) != 5 || events[4] != "cycle A -> B -> A" {
+			t.Errorf("%s: события %q, ожидались четыре узла и затем цикл", traversal, events)
+		}
+	}
+}
+
+// TestBOM: output_format=bom перечисляет все найденные пакеты замыкания с версией
+// и лицензией, помечая отсутствующую лицензию как UNKNOWN
+func TestBOM(t *testing.T) {
+	const index = "Package: app\nVersion: 1.0\nLicense: MIT\nSection: utils\nDepends: libx, liby, mta, ghost\n\n" +
+		"Package: libx\nVersion: 2.3\nCopyright: BSD-3-Clause\nSection: libs\n\n" +
+		"Package: liby\nVersion: 0.5-1\nSection: libs\nDepends: postfix\n\n" +
+		"Package: postfix\nVersion: 3.7\nLicense: IPL-1.0\nCopyright: ignored\nSection: mail\nProvides: mta\n"
+	graph := buildTestGraph(t, index, "app")
+	if _, ok := graph.Nodes["ghost"]; !ok {
+		t.Fatalf("ненайденная зависимость ghost должна быть узлом графа: %v", slices.Sorted(maps.Keys(graph.Nodes)))
+	}
+
+	var buf bytes.Buffer
+	printBOM(&buf, graph)
+	want := []string{
+		"name\tversion\tlicense\tsection",
+		"app\t1.0\tMIT\tutils",
+		"libx\t2.3\tBSD-3-Clause\tlibs",
+		"liby\t0.5-1\tUNKNOWN\tlibs",
+		"postfix\t3.7\tIPL-1.0\tmail",
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); !slices.Equal(got, want) {
+		t.Errorf("BOM:\n%s\nожидалось:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestSuiteLayout: при заданном suite repository_url считается корнем архива,
+// а индекс читается из dists/<suite>/<component>/binary-<arch>/Packages
+func TestSuiteLayout(t *testing.T) {
+	root := t.TempDir()
+	indexDir := filepath.Join(root, "dists", "focal", "universe", "binary-arm64")
+	if err := os.MkdirAll(indexDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, indexDir, "Packages", "Package: A\nVersion: 1\nDepends: B\n\nPackage: B\nVersion: 2\n")
+
+	config := loadTestConfig(t, "", "A", "repository_url,"+root+"/", "suite,/focal/",
+		"component,universe", "architecture,arm64")
+	if want := root + "/dists/focal/universe/binary-arm64/Packages"; config.RepositoryURL != want {
+		t.Errorf("RepositoryURL = %q, ожидалось %q", config.RepositoryURL, want)
+	}
+	var graph *Graph
+	var err error
+	captureOutput(t, func() { graph, err = buildDependencyGraph(config) })
+	if err != nil {
+		t.Fatalf("buildDependencyGraph: %v", err)
+	}
+	if names := slices.Sorted(maps.Keys(graph.Nodes)); !slices.Equal(names, []string{"A", "B"}) {
+		t.Errorf("узлы = %v, ожидались A и B из индекса раскладки", names)
+	}
+
+	// По сети берётся сжатый индекс, компонент и архитектура по умолчанию — main и amd64
+	remote := loadTestConfig(t, "", "A", "repository_url,http://deb.example.org/debian", "suite,stable", "test_mode,false")
+	if want := "http://deb.example.org/debian/dists/stable/main/binary-amd64/Packages.gz"; remote.RepositoryURL != want {
+		t.Errorf("RepositoryURL = %q, ожидалось %q", remote.RepositoryURL, want)
+	}
+}
+
+// TestLenientParse: при lenient_parse повреждённые записи отбрасываются с номером
+// строки, а остальные пакеты индекса разбираются; без него длинная строка — ошибка
+func TestLenientParse(t *testing.T) {
+	index := "Package: A\nVersion: 1\n\n" +
+		"Package: B\nVersion: 2\nthis line is broken\n\n" +
+		"Package: C\nVersion: 3\n\n" +
+		"Package: D\nDescription: " + strings.Repeat("x", bufio.MaxScanTokenSize) + "\nVersion: 4\n\n" +
+		"Package: E\nDepends: A\n\n" +
+		"Package: F\nVersion: 6\n"
+
+	packages, malformed, err := parsePackagesFile(strings.NewReader(index), ParseOptions{Lenient: true})
+	if err != nil {
+		t.Fatalf("parsePackagesFile: %v", err)
+	}
+	var names []string
+	for _, pkg := range packages {
+		names = append(names, pkg.Name+"="+pkg.Version)
+	}
+	if want := []string{"A=1", "C=3", "F=6"}; !slices.Equal(names, want) {
+		t.Errorf("пакеты = %v, ожидалось %v", names, want)
+	}
+	want := []string{
+		`строка 6: нет разделителя поля: "this line is broken"`,
+		fmt.Sprintf("строка 12: строка длиннее %d байт", bufio.MaxScanTokenSize),
+		"строка 15: у пакета E нет поля Version",
+	}
+	if !slices.Equal(malformed, want) {
+		t.Errorf("повреждённые записи:\n%s\nожидалось:\n%s", strings.Join(malformed, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, _, err := parsePackagesFile(strings.NewReader(index), ParseOptions{}); err == nil || exitCodeFor(err) != ExitParseError {
+		t.Errorf("без lenient_parse ожидалась ошибка разбора, получено: %v", err)
+	}
+}